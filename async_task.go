@@ -3,6 +3,11 @@ package common
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"runtime/debug"
+	"sync"
 	"time"
 )
 
@@ -15,6 +20,8 @@ type IAsyncTask interface {
 type managedObject struct {
 	breakChan  chan struct{}
 	finishChan chan struct{}
+	// breakChan закрывается ровно один раз даже при одновременных вызовах Break/BreakAndWait
+	breakOnce *sync.Once
 }
 
 func newManagedObject() managedObject {
@@ -26,19 +33,19 @@ func newManagedObject() managedObject {
 func (ptr *managedObject) resetChans() {
 	ptr.breakChan = make(chan struct{})
 	ptr.finishChan = make(chan struct{})
+	ptr.breakOnce = &sync.Once{}
 }
 
 func (ptr *managedObject) Break() {
-	if !ptr.IsStoped() {
+	ptr.breakOnce.Do(func() {
 		close(ptr.breakChan)
-	}
+	})
 }
 
+// повторные и одновременные вызовы тоже дожидаются завершения задачи
 func (ptr *managedObject) BreakAndWait() {
-	if !ptr.IsStoped() {
-		close(ptr.breakChan)
-		<-ptr.finishChan
-	}
+	ptr.Break()
+	<-ptr.finishChan
 }
 
 func (ptr *managedObject) IsStoped() bool {
@@ -58,20 +65,39 @@ type AsyncTaskFunc = func(breakChan <-chan struct{})
 
 type asyncTask struct {
 	managedObject
-	task AsyncTaskFunc
+	task         AsyncTaskFunc
+	errorHandler func(error)
 }
 
 func NewAsyncTask(task AsyncTaskFunc) IAsyncTask {
+	return NewAsyncTaskWithErrorHandler(task, nil)
+}
+
+// паника в задаче перехватывается и передаётся в errorHandler в виде ошибки (при nil выводится в лог)
+func NewAsyncTaskWithErrorHandler(task AsyncTaskFunc, errorHandler func(error)) IAsyncTask {
 	return &asyncTask{
 		managedObject: newManagedObject(),
 		task:          task,
+		errorHandler:  errorHandler,
 	}
 }
 
 func (ptr *asyncTask) Execute() {
 	go func() {
+		// finishChan закрывается и при панике в задаче, иначе BreakAndWait никогда не вернётся
+		defer close(ptr.finishChan)
+		defer func() {
+			if r := recover(); r != nil {
+				err := fmt.Errorf("async task panicked: %v\n%s", r, debug.Stack())
+				if ptr.errorHandler != nil {
+					ptr.errorHandler(err)
+				} else {
+					log.Println("E> " + err.Error())
+				}
+			}
+		}()
+
 		ptr.task(ptr.breakChan)
-		close(ptr.finishChan)
 	}()
 }
 
@@ -83,32 +109,117 @@ type RepeatableTaskFunc = func()
 
 type repeatableTask struct {
 	managedObject
-	task    RepeatableTaskFunc
-	timeout time.Duration
+	task           RepeatableTaskFunc
+	timeout        time.Duration
+	runImmediately bool
+	jitter         time.Duration
 }
 
+// задача выполняется сразу после запуска, затем через каждый интервал timeout
 func NewRepeatableTask(task RepeatableTaskFunc, timeout time.Duration) IAsyncTask {
+	return NewRepeatableTaskOpts(task, timeout, true)
+}
+
+// при runImmediately == false первое выполнение задачи происходит через интервал timeout после запуска
+func NewRepeatableTaskOpts(task RepeatableTaskFunc, timeout time.Duration, runImmediately bool) IAsyncTask {
 	return &repeatableTask{
-		managedObject: newManagedObject(),
-		task:          task,
-		timeout:       timeout,
+		managedObject:  newManagedObject(),
+		task:           task,
+		timeout:        timeout,
+		runImmediately: runImmediately,
+	}
+}
+
+// интервал между выполнениями случайно изменяется в пределах ±jitter на каждом цикле,
+// чтобы задачи с одинаковым интервалом не выполнялись одновременно
+func NewRepeatableTaskJitter(task RepeatableTaskFunc, timeout, jitter time.Duration) IAsyncTask {
+	return &repeatableTask{
+		managedObject:  newManagedObject(),
+		task:           task,
+		timeout:        timeout,
+		runImmediately: true,
+		jitter:         jitter,
 	}
 }
 
+func (ptr *repeatableTask) interval() time.Duration {
+	if ptr.jitter <= 0 {
+		return ptr.timeout
+	}
+
+	interval := ptr.timeout + time.Duration(rand.Int63n(2*int64(ptr.jitter)+1)) - ptr.jitter
+	if interval < 0 {
+		return 0
+	}
+	return interval
+}
+
 func (ptr *repeatableTask) Execute() {
 	go func() {
 		defer close(ptr.finishChan)
-		timer := time.NewTimer(ptr.timeout)
-		for {
+
+		if ptr.runImmediately {
 			ptr.task()
+		}
 
+		// интервал отсчитывается от завершения предыдущего выполнения задачи
+		timer := time.NewTimer(ptr.interval())
+		defer timer.Stop()
+
+		for {
 			select {
 			case <-timer.C:
+				ptr.task()
+				timer.Reset(ptr.interval())
+			case <-ptr.breakChan:
+				return
+			}
+		}
+	}()
+}
+
+/*
+ScheduledTask
+*/
+
+type scheduledTask struct {
+	managedObject
+	task     RepeatableTaskFunc
+	schedule *cronSchedule
+}
+
+// задача выполняется в моменты, соответствующие cron-расписанию schedule (например "0 * * * *" - в начале каждого часа)
+func NewScheduledTask(task RepeatableTaskFunc, schedule string) (IAsyncTask, error) {
+	cron, err := parseCronSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scheduledTask{
+		managedObject: newManagedObject(),
+		task:          task,
+		schedule:      cron,
+	}, nil
+}
+
+func (ptr *scheduledTask) Execute() {
+	go func() {
+		defer close(ptr.finishChan)
+
+		for {
+			next := ptr.schedule.next(time.Now())
+			if next.IsZero() {
+				log.Println("W> scheduled task stopped, no next run time found")
 				return
+			}
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+				ptr.task()
 			case <-ptr.breakChan:
+				timer.Stop()
 				return
-			default:
-				timer.Reset(ptr.timeout)
 			}
 		}
 	}()
@@ -123,18 +234,91 @@ type TasksExecutor struct {
 	tasks            chan func()
 	terminate        bool
 	monitoringParams *MonitoringParams
+	workers          int
+	stats            tasksStatsCollector
+	limiter          *rateLimiter
 }
 
 type MonitoringParams struct {
 	Interval     time.Duration
 	UserCallback func(used int)
+	// статистика ожидания и выполнения задач, завершённых за интервал
+	StatsCallback func(TasksStats)
+}
+
+type TasksStats struct {
+	// заполненность очереди в процентах
+	Used    int
+	Tasks   int
+	AvgWait time.Duration
+	MaxWait time.Duration
+	AvgRun  time.Duration
+	MaxRun  time.Duration
 }
 
-func NewTasksExecutor(queueSize int, params *MonitoringParams) *TasksExecutor {
+type tasksStatsCollector struct {
+	mu        sync.Mutex
+	tasks     int
+	totalWait time.Duration
+	maxWait   time.Duration
+	totalRun  time.Duration
+	maxRun    time.Duration
+}
+
+func (ptr *tasksStatsCollector) add(wait, run time.Duration) {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	ptr.tasks++
+	ptr.totalWait += wait
+	ptr.totalRun += run
+	if wait > ptr.maxWait {
+		ptr.maxWait = wait
+	}
+	if run > ptr.maxRun {
+		ptr.maxRun = run
+	}
+}
+
+// статистика за прошедший интервал, после чтения накопленные значения сбрасываются
+func (ptr *tasksStatsCollector) reset() TasksStats {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	stats := TasksStats{
+		Tasks:   ptr.tasks,
+		MaxWait: ptr.maxWait,
+		MaxRun:  ptr.maxRun,
+	}
+	if ptr.tasks > 0 {
+		stats.AvgWait = ptr.totalWait / time.Duration(ptr.tasks)
+		stats.AvgRun = ptr.totalRun / time.Duration(ptr.tasks)
+	}
+
+	ptr.tasks = 0
+	ptr.totalWait, ptr.maxWait = 0, 0
+	ptr.totalRun, ptr.maxRun = 0, 0
+	return stats
+}
+
+// workers - количество горутин, параллельно выполняющих задачи из очереди,
+// при значении 0 или 1 задачи выполняются последовательно в порядке добавления
+func NewTasksExecutor(queueSize int, workers int, params *MonitoringParams) *TasksExecutor {
+	return NewTasksExecutorWithRateLimit(queueSize, workers, params, RateLimit{})
+}
+
+// перед выполнением каждой задачи исполнитель ждёт свободный токен limit, при нулевом limit.PerSecond ограничения нет
+func NewTasksExecutorWithRateLimit(queueSize int, workers int, params *MonitoringParams, limit RateLimit) *TasksExecutor {
+	if workers < 1 {
+		workers = 1
+	}
+
 	return &TasksExecutor{
 		managedObject:    newManagedObject(),
 		tasks:            make(chan func(), queueSize),
 		monitoringParams: params,
+		workers:          workers,
+		limiter:          newRateLimiter(limit),
 	}
 }
 
@@ -145,10 +329,16 @@ func (ptr *TasksExecutor) TaskQueueLen() int {
 func (ptr *TasksExecutor) Run() {
 	ptr.resetChans()
 
-	if ptr.monitoringParams != nil && cap(ptr.tasks) > 0 {
+	if ptr.monitoringParams != nil && (cap(ptr.tasks) > 0 || ptr.monitoringParams.StatsCallback != nil) {
 		go ptr.monitoringCycle()
 	}
-	go ptr.executionCycle()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(ptr.workers)
+	for i := 0; i < ptr.workers; i++ {
+		go ptr.executionCycle(wg)
+	}
+	go ptr.finishCycle(wg)
 }
 
 func (ptr *TasksExecutor) Terminate() {
@@ -164,7 +354,7 @@ func (ptr *TasksExecutor) Execute(taskName string, task func()) error {
 	}
 
 	select {
-	case ptr.tasks <- task:
+	case ptr.tasks <- ptr.withStats(task):
 	default:
 		return errors.New("execute " + taskName + " task failed, tasks queue is full")
 	}
@@ -186,7 +376,7 @@ func (ptr *TasksExecutor) ExecuteAnyway(ctx context.Context, taskName string, ta
 	}
 
 	select {
-	case ptr.tasks <- taskWithContext:
+	case ptr.tasks <- ptr.withStats(taskWithContext):
 	case <-ctx.Done():
 	}
 
@@ -209,6 +399,35 @@ func (ptr *TasksExecutor) ExecuteAndWait(taskName string, task func()) error {
 	return nil
 }
 
+// в отличие от ExecuteAndWait не ждёт выполнения задачи после отмены ctx или остановки исполнителя
+func (ptr *TasksExecutor) ExecuteAndWaitCtx(ctx context.Context, taskName string, task func()) error {
+	// буферизованный канал, чтобы исполнитель не блокировался, если вызывающий уже перестал ждать
+	done := make(chan struct{}, 1)
+
+	err := ptr.Execute(taskName, func() {
+		task()
+		done <- struct{}{}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ptr.breakChan:
+		select {
+		case <-done:
+			return nil
+		default:
+			return errors.New("tasks executor stopped before " + taskName + " task completed")
+		}
+	}
+}
+
 func (ptr *TasksExecutor) ExecuteAndWaitError(taskName string, task func() error) error {
 	result := make(chan error, 1)
 
@@ -223,43 +442,69 @@ func (ptr *TasksExecutor) ExecuteAndWaitError(taskName string, task func() error
 	return <-result
 }
 
-func (ptr *TasksExecutor) executionCycle() {
-
-	// очерёдность исполнения defer функций происходит в порядке обратном объявлению, т.е. эта функция исполнится последней
-	defer close(ptr.finishChan)
+func (ptr *TasksExecutor) executionCycle(wg *sync.WaitGroup) {
+	defer wg.Done()
 
-	// завершение обработки всех задач находящихся в очереди на момент остановки
-	defer func() {
-		if ptr.terminate {
-			return
-		}
-		for {
-			select {
-			case task := <-ptr.tasks:
-				{
-					task()
+	for {
+		select {
+		case task := <-ptr.tasks:
+			{
+				// при остановке во время ожидания токена задача выполняется сразу, как и остальные задачи очереди
+				if ptr.limiter != nil && !ptr.limiter.wait(ptr.breakChan) {
+					if !ptr.terminate {
+						task()
+					}
+					return
 				}
-			default:
-				return
+				task()
 			}
+		case <-ptr.breakChan:
+			return
 		}
-	}()
+	}
+}
+
+// дожидается остановки всех исполнителей и однократно завершает обработку очереди
+func (ptr *TasksExecutor) finishCycle(wg *sync.WaitGroup) {
+	defer close(ptr.finishChan)
 
+	wg.Wait()
+
+	// завершение обработки всех задач находящихся в очереди на момент остановки
+	if ptr.terminate {
+		return
+	}
 	for {
 		select {
 		case task := <-ptr.tasks:
 			{
+				// ограничение частоты при завершении не применяется, чтобы не задерживать остановку
 				task()
 			}
-		case <-ptr.breakChan:
+		default:
 			return
 		}
 	}
 }
 
+// замер времени ожидания в очереди и выполнения задачи, если задан StatsCallback
+func (ptr *TasksExecutor) withStats(task func()) func() {
+	if ptr.monitoringParams == nil || ptr.monitoringParams.StatsCallback == nil {
+		return task
+	}
+
+	enqueued := time.Now()
+	return func() {
+		started := time.Now()
+		task()
+		ptr.stats.add(started.Sub(enqueued), time.Since(started))
+	}
+}
+
 func (ptr *TasksExecutor) monitoringCycle() {
 	callback := ptr.monitoringParams.UserCallback
-	if callback == nil {
+	statsCallback := ptr.monitoringParams.StatsCallback
+	if callback == nil && statsCallback == nil {
 		return
 	}
 
@@ -270,10 +515,18 @@ func (ptr *TasksExecutor) monitoringCycle() {
 		select {
 		case <-timer.C:
 			{
-				capacity := cap(ptr.tasks)
-				length := len(ptr.tasks)
-				used := 100 * length / capacity
-				callback(used)
+				var used int
+				if capacity := cap(ptr.tasks); capacity > 0 {
+					used = 100 * len(ptr.tasks) / capacity
+				}
+				if callback != nil && cap(ptr.tasks) > 0 {
+					callback(used)
+				}
+				if statsCallback != nil {
+					stats := ptr.stats.reset()
+					stats.Used = used
+					statsCallback(stats)
+				}
 				timer.Reset(interval)
 			}
 		case <-ptr.breakChan:
@@ -282,6 +535,78 @@ func (ptr *TasksExecutor) monitoringCycle() {
 	}
 }
 
+/*
+RateLimit - ограничение частоты выполнения задач по алгоритму token bucket:
+PerSecond - скорость пополнения токенов, Burst - максимальное количество накопленных токенов (не меньше 1)
+*/
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.PerSecond <= 0 {
+		return nil
+	}
+
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:   limit.PerSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// берёт токен или возвращает время до появления следующего, токены (включая дробные) накапливаются до Burst
+func (ptr *rateLimiter) take() (time.Duration, bool) {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	now := time.Now()
+	ptr.tokens += now.Sub(ptr.last).Seconds() * ptr.rate
+	ptr.last = now
+	if ptr.tokens > ptr.burst {
+		ptr.tokens = ptr.burst
+	}
+
+	if ptr.tokens >= 1 {
+		ptr.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - ptr.tokens) / ptr.rate * float64(time.Second)), false
+}
+
+// false, если ожидание прервано закрытием breakChan
+func (ptr *rateLimiter) wait(breakChan <-chan struct{}) bool {
+	for {
+		delay, ok := ptr.take()
+		if ok {
+			return true
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-breakChan:
+			timer.Stop()
+			return false
+		}
+	}
+}
+
 func ExecuteWithTimeout(timeout time.Duration, task, onTimeout func()) {
 	timer := time.NewTimer(timeout)
 	defer timer.Stop()
@@ -0,0 +1,127 @@
+package common
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTasksExecutorRateLimitThroughput(t *testing.T) {
+	executor := NewTasksExecutorWithRateLimit(2000, 4, nil, RateLimit{PerSecond: 500, Burst: 1})
+	executor.Run()
+	defer executor.Terminate()
+
+	var executed int64
+	for i := 0; i < 2000; i++ {
+		if err := executor.Execute("count", func() { atomic.AddInt64(&executed, 1) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(time.Second)
+
+	// допускается погрешность таймеров, но не ограничение пропускной способности ниже заданной скорости
+	if n := atomic.LoadInt64(&executed); n < 400 || n > 560 {
+		t.Fatalf("executed %d tasks in 1s, expected about 500", n)
+	}
+}
+
+func TestTasksExecutorRateLimitIdleWorkersDontHoldTokens(t *testing.T) {
+	executor := NewTasksExecutorWithRateLimit(10, 4, nil, RateLimit{PerSecond: 1, Burst: 1})
+	executor.Run()
+	defer executor.Terminate()
+
+	// простаивающие исполнители не должны накапливать токены сверх Burst
+	time.Sleep(50 * time.Millisecond)
+
+	var executed int64
+	for i := 0; i < 4; i++ {
+		executor.Execute("count", func() { atomic.AddInt64(&executed, 1) })
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if n := atomic.LoadInt64(&executed); n != 1 {
+		t.Fatalf("executed %d tasks, expected burst of 1", n)
+	}
+}
+
+func TestTasksExecutorRateLimitDoesntDelayStop(t *testing.T) {
+	executor := NewTasksExecutorWithRateLimit(10, 1, nil, RateLimit{PerSecond: 0.1, Burst: 1})
+	executor.Run()
+
+	var executed int64
+	for i := 0; i < 3; i++ {
+		executor.Execute("count", func() { atomic.AddInt64(&executed, 1) })
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	executor.BreakAndWait()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("stop took %v", elapsed)
+	}
+	if n := atomic.LoadInt64(&executed); n != 3 {
+		t.Fatalf("executed %d tasks, expected queue to be drained", n)
+	}
+}
+
+func TestBreakAndWaitConcurrentCallersWait(t *testing.T) {
+	var finished int32
+	task := NewAsyncTask(func(breakChan <-chan struct{}) {
+		<-breakChan
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	})
+	task.Execute()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task.BreakAndWait()
+			if atomic.LoadInt32(&finished) != 1 {
+				t.Error("BreakAndWait returned before task finished")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// повторный вызов после завершения не блокируется
+	task.BreakAndWait()
+}
+
+func TestAsyncTaskPanicRecovered(t *testing.T) {
+	errs := make(chan error, 1)
+	task := NewAsyncTaskWithErrorHandler(func(breakChan <-chan struct{}) {
+		panic("boom")
+	}, func(err error) {
+		errs <- err
+	})
+	task.Execute()
+
+	done := make(chan struct{})
+	go func() {
+		task.BreakAndWait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("BreakAndWait didn't return after task panic")
+	}
+
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "panicked: boom") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	default:
+		t.Fatal("panic wasn't passed to error handler")
+	}
+}
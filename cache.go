@@ -0,0 +1,96 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheItem[V any] struct {
+	value      V
+	expiration time.Time
+}
+
+func (item *cacheItem[V]) expired(now time.Time) bool {
+	return !item.expiration.IsZero() && now.After(item.expiration)
+}
+
+/*
+Cache - concurrency-safe key-value cache with per-item TTL,
+expired items are evicted by background janitor until Close is called
+*/
+type Cache[K comparable, V any] struct {
+	mu      sync.RWMutex
+	items   map[K]cacheItem[V]
+	janitor IAsyncTask
+}
+
+// cleanupInterval <= 0 - фоновая очистка отключена, просроченные элементы
+// не возвращаются из Get, но остаются в памяти до Delete или перезаписи через Set
+func NewCache[K comparable, V any](cleanupInterval time.Duration) *Cache[K, V] {
+	cache := &Cache[K, V]{
+		items: make(map[K]cacheItem[V]),
+	}
+
+	if cleanupInterval > 0 {
+		cache.janitor = NewRepeatableTaskOpts(cache.deleteExpired, cleanupInterval, false)
+		cache.janitor.Execute()
+	}
+
+	return cache
+}
+
+func (ptr *Cache[K, V]) Get(key K) (V, bool) {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	item, ok := ptr.items[key]
+	if !ok || item.expired(time.Now()) {
+		var zero V
+		return zero, false
+	}
+
+	return item.value, true
+}
+
+// ttl <= 0 - значение хранится до явного удаления
+func (ptr *Cache[K, V]) Set(key K, value V, ttl time.Duration) {
+	item := cacheItem[V]{value: value}
+	if ttl > 0 {
+		item.expiration = time.Now().Add(ttl)
+	}
+
+	ptr.mu.Lock()
+	ptr.items[key] = item
+	ptr.mu.Unlock()
+}
+
+func (ptr *Cache[K, V]) Delete(key K) {
+	ptr.mu.Lock()
+	delete(ptr.items, key)
+	ptr.mu.Unlock()
+}
+
+func (ptr *Cache[K, V]) Len() int {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+	return len(ptr.items)
+}
+
+func (ptr *Cache[K, V]) Close() {
+	if ptr.janitor != nil {
+		ptr.janitor.BreakAndWait()
+	}
+}
+
+func (ptr *Cache[K, V]) deleteExpired() {
+	now := time.Now()
+
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	for key, item := range ptr.items {
+		if item.expired(now) {
+			delete(ptr.items, key)
+		}
+	}
+}
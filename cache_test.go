@@ -0,0 +1,66 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheExpiry(t *testing.T) {
+	cache := NewCache[string, int](0)
+	defer cache.Close()
+
+	cache.Set("short", 1, 20*time.Millisecond)
+	cache.Set("forever", 2, 0)
+
+	if v, ok := cache.Get("short"); !ok || v != 1 {
+		t.Fatalf("Get(short) = %d, %v before expiry", v, ok)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if _, ok := cache.Get("short"); ok {
+		t.Fatal("expired item returned from Get")
+	}
+	if v, ok := cache.Get("forever"); !ok || v != 2 {
+		t.Fatalf("Get(forever) = %d, %v", v, ok)
+	}
+
+	// без фоновой очистки просроченный элемент остаётся в кэше
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("Len() = %d without janitor, expected 2", n)
+	}
+}
+
+func TestCacheJanitorEvictsExpired(t *testing.T) {
+	cache := NewCache[string, int](10 * time.Millisecond)
+
+	cache.Set("short", 1, 5*time.Millisecond)
+	cache.Set("forever", 2, 0)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("Len() = %d after cleanup, expected 1", n)
+	}
+
+	cache.Close()
+
+	// после остановки очистка больше не выполняется
+	cache.Set("short", 1, time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("Len() = %d after Close, expected 2", n)
+	}
+}
+
+func TestCacheDelete(t *testing.T) {
+	cache := NewCache[int, string](time.Minute)
+	defer cache.Close()
+
+	cache.Set(1, "one", 0)
+	cache.Delete(1)
+
+	if _, ok := cache.Get(1); ok {
+		t.Fatal("deleted item returned from Get")
+	}
+}
@@ -1,31 +1,113 @@
 package common
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
+// ширина значения в существующих файлах, созданных до поддержки произвольной ширины
+const int64ValueSizeInBytes = 8
+
+// операции с файлом, используемые хранилищем (*os.File)
+type storageFile interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+	Sync() error
+}
+
 type FileStorage struct {
-	filename      string
-	file          *os.File
+	mu       sync.RWMutex
+	filename string
+	file     storageFile
+	// расстояние между соседними значениями в файле, не меньше width
 	bytesPerValue int8
+	// ширина значения в байтах: 1, 2, 4 или 8
+	width int8
+	order binary.ByteOrder
+	// fsync после каждой записи значения
+	syncOnWrite bool
 }
 
-func NewFileStorage(name string, bytesPerValue int8) *FileStorage {
+/*
+NewFileStorage - storage of values of width bytes (1, 2, 4 or 8) placed one after another in byte order
+*/
+func NewFileStorage(name string, width int8, order binary.ByteOrder) *FileStorage {
+	return &FileStorage{
+		filename:      name,
+		bytesPerValue: width,
+		width:         width,
+		order:         order,
+	}
+}
+
+/*
+NewFileStorageInt64 - storage with 8-byte little-endian values placed every bytesPerValue bytes (layout of existing files)
+*/
+func NewFileStorageInt64(name string, bytesPerValue int8) *FileStorage {
 	return &FileStorage{
 		filename:      name,
 		bytesPerValue: bytesPerValue,
+		width:         int64ValueSizeInBytes,
+		order:         binary.LittleEndian,
+	}
+}
+
+/*
+SetSyncOnWrite - fsync after every SetValue/SetFloat, so returned write survives crash or power loss.
+Each write then waits for the disk (milliseconds instead of microseconds), so enable it only for rarely
+updated critical values (e.g. processing cursor) and call Sync manually after batches otherwise
+*/
+func (ptr *FileStorage) SetSyncOnWrite(enabled bool) {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	ptr.syncOnWrite = enabled
+}
+
+// сброс записанных значений из кеша ОС на диск
+func (ptr *FileStorage) Sync() error {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	if ptr.file == nil {
+		return errors.New("file is not open")
 	}
+
+	return ptr.file.Sync()
 }
 
 func (ptr *FileStorage) Start() error {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
 	if ptr.file != nil {
 		return errors.New("file descriptor is not nil")
 	}
 
+	switch ptr.width {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("unsupported value width %d bytes", ptr.width)
+	}
+	if ptr.bytesPerValue < ptr.width {
+		return fmt.Errorf("bytes per value %d is less than value width %d", ptr.bytesPerValue, ptr.width)
+	}
+	if ptr.order == nil {
+		return errors.New("byte order is not set")
+	}
+
 	file, err := os.OpenFile(ptr.filename, os.O_RDWR, 0644)
 	if err != nil {
 		if file, err = os.Create(ptr.filename); err != nil {
@@ -38,6 +120,9 @@ func (ptr *FileStorage) Start() error {
 }
 
 func (ptr *FileStorage) Stop() error {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
 	if ptr.file == nil {
 		return nil
 	}
@@ -47,59 +132,407 @@ func (ptr *FileStorage) Stop() error {
 	return err
 }
 
+// значение, не помещающееся в ширину хранилища, не записывается
 func (ptr *FileStorage) SetValue(value uint64, offset int64) error {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	return ptr.writeValue(value, offset)
+}
+
+func (ptr *FileStorage) GetValue(offset int64) (uint64, error) {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	return ptr.readValue(offset)
+}
+
+// поддерживаются хранилища шириной 4 (float32) и 8 (float64) байт
+func (ptr *FileStorage) SetFloat(value float64, offset int64) error {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	switch ptr.width {
+	case 4:
+		return ptr.writeValue(uint64(math.Float32bits(float32(value))), offset)
+	case 8:
+		return ptr.writeValue(math.Float64bits(value), offset)
+	}
+	return fmt.Errorf("float values are not supported for width %d bytes", ptr.width)
+}
+
+func (ptr *FileStorage) GetFloat(offset int64) (float64, error) {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	if ptr.width != 4 && ptr.width != 8 {
+		return 0, fmt.Errorf("float values are not supported for width %d bytes", ptr.width)
+	}
+
+	value, err := ptr.readValue(offset)
+	if err != nil {
+		return 0, err
+	}
+	if ptr.width == 4 {
+		return float64(math.Float32frombits(uint32(value))), nil
+	}
+	return math.Float64frombits(value), nil
+}
+
+func (ptr *FileStorage) writeValue(value uint64, offset int64) error {
 	if ptr.file == nil {
 		return errors.New("file is not open")
 	}
 
-	var buf bytes.Buffer
-	if err := binary.Write(&buf, binary.LittleEndian, value); err != nil {
-		return err
+	if ptr.width < 8 && value>>(8*uint(ptr.width)) != 0 {
+		return fmt.Errorf("value %d overflows %d bytes", value, ptr.width)
 	}
 
-	seekOffset, err := ptr.file.Seek(offset*int64(ptr.bytesPerValue), os.SEEK_SET)
+	if offset < 0 {
+		return fmt.Errorf("negative offset %d", offset)
+	}
+	position := offset * int64(ptr.bytesPerValue)
+
+	info, err := ptr.file.Stat()
 	if err != nil {
 		return err
 	}
+	// при записи за концом файла он расширяется нулями (разреженно), пропущенные значения читаются как 0
+	if end := position + int64(ptr.width); end > info.Size() {
+		if err := ptr.file.Truncate(end); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, ptr.width)
+	ptr.encode(buf, value)
 
-	if _, err := ptr.file.WriteAt(buf.Bytes(), seekOffset); err != nil {
+	// WriteAt не использует текущую позицию файла, поэтому Seek не нужен
+	n, err := ptr.file.WriteAt(buf, position)
+	if err != nil {
 		return err
 	}
+	if n != len(buf) {
+		return fmt.Errorf("short write, %d of %d bytes written", n, len(buf))
+	}
 
+	if ptr.syncOnWrite {
+		return ptr.file.Sync()
+	}
 	return nil
 }
 
-func (ptr *FileStorage) GetValue(offset int64) (uint64, error) {
+func (ptr *FileStorage) readValue(offset int64) (uint64, error) {
+	if ptr.file == nil {
+		return 0, errors.New("file is not open")
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+
+	// значения за концом файла и в не записанных областях считаются нулевыми
+	buf := make([]byte, ptr.width)
+	n, err := ptr.file.ReadAt(buf, offset*int64(ptr.bytesPerValue))
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if n != len(buf) {
+		return 0, fmt.Errorf("short read, %d of %d bytes read", n, len(buf))
+	}
+
+	return ptr.decode(buf), nil
+}
+
+func (ptr *FileStorage) encode(buf []byte, value uint64) {
+	switch ptr.width {
+	case 1:
+		buf[0] = byte(value)
+	case 2:
+		ptr.order.PutUint16(buf, uint16(value))
+	case 4:
+		ptr.order.PutUint32(buf, uint32(value))
+	default:
+		ptr.order.PutUint64(buf, value)
+	}
+}
+
+func (ptr *FileStorage) decode(buf []byte) uint64 {
+	switch ptr.width {
+	case 1:
+		return uint64(buf[0])
+	case 2:
+		return uint64(ptr.order.Uint16(buf))
+	case 4:
+		return uint64(ptr.order.Uint32(buf))
+	default:
+		return ptr.order.Uint64(buf)
+	}
+}
+
+func (ptr *FileStorage) CleanStorage() error {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	if ptr.file == nil {
+		return errors.New("file is not open")
+	}
+
+	return ptr.file.Truncate(0)
+}
+
+// количество значений в хранилище, включая не записанные явно значения внутри файла
+func (ptr *FileStorage) Len() (int64, error) {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
 
 	if ptr.file == nil {
 		return 0, errors.New("file is not open")
 	}
 
-	seekOffset, err := ptr.file.Seek(offset*int64(ptr.bytesPerValue), os.SEEK_SET)
+	return ptr.count()
+}
+
+/*
+ForEach - calling fn for every stored value in offset order, iteration stops on the first error returned by fn.
+Storage is read-locked during iteration, so fn must not write to the same storage
+*/
+func (ptr *FileStorage) ForEach(fn func(offset int64, value int64) error) error {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	if ptr.file == nil {
+		return errors.New("file is not open")
+	}
+
+	return ptr.forEachValue(func(offset int64, value uint64) error {
+		return fn(offset, int64(value))
+	})
+}
+
+func (ptr *FileStorage) count() (int64, error) {
+	info, err := ptr.file.Stat()
 	if err != nil {
-		if err == io.EOF {
-			return 0, nil
-		}
 		return 0, err
 	}
 
-	buf := make([]byte, 8)
-	if _, err := ptr.file.ReadAt(buf, seekOffset); err != nil {
-		if err == io.EOF {
-			return 0, nil
+	// последнее значение может не дополняться до полного шага bytesPerValue
+	if info.Size() < int64(ptr.width) {
+		return 0, nil
+	}
+	return (info.Size()-int64(ptr.width))/int64(ptr.bytesPerValue) + 1, nil
+}
+
+// последовательное чтение файла через буфер вместо ReadAt на каждое значение
+func (ptr *FileStorage) forEachValue(fn func(offset int64, value uint64) error) error {
+	count, err := ptr.count()
+	if err != nil {
+		return err
+	}
+
+	stride := int64(ptr.bytesPerValue)
+	reader := bufio.NewReader(io.NewSectionReader(ptr.file, 0, (count-1)*stride+int64(ptr.width)))
+	buf := make([]byte, stride)
+
+	for offset := int64(0); offset < count; offset++ {
+		// шаг последнего значения может выходить за конец файла, поэтому читается только само значение
+		chunk := buf
+		if offset == count-1 {
+			chunk = buf[:ptr.width]
 		}
-		return 0, err
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return err
+		}
+		if err := fn(offset, ptr.decode(buf[:ptr.width])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Snapshot - copying current storage contents to destPath, concurrent writes are blocked during copying
+*/
+func (ptr *FileStorage) Snapshot(destPath string) error {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	if ptr.file == nil {
+		return errors.New("file is not open")
+	}
+
+	info, err := ptr.file.Stat()
+	if err != nil {
+		return err
 	}
 
-	value := binary.LittleEndian.Uint64(buf)
+	data := make([]byte, info.Size())
+	if _, err := ptr.file.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
 
-	return value, nil
+	return AtomicWriteFile(destPath, data, 0644)
 }
 
-func (ptr *FileStorage) CleanStorage() error {
+/*
+Restore - replacing storage contents with the file at srcPath and reopening storage
+*/
+func (ptr *FileStorage) Restore(srcPath string) error {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
 	if ptr.file == nil {
 		return errors.New("file is not open")
 	}
 
-	return ptr.file.Truncate(0)
+	data, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+
+	if err := AtomicWriteFile(ptr.filename, data, 0644); err != nil {
+		return err
+	}
+
+	// после переименования старый дескриптор указывает на удалённый файл
+	file, err := os.OpenFile(ptr.filename, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	ptr.file.Close()
+	ptr.file = file
+	return nil
+}
+
+/*
+Rewrite - rewriting storage to temporary file with only kept values at their offsets and replacing the original,
+dropped values become holes in sparse file, so disk space is reclaimed
+*/
+func (ptr *FileStorage) Rewrite(keep func(offset int64, value int64) bool) error {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	if ptr.file == nil {
+		return errors.New("file is not open")
+	}
+
+	info, err := ptr.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(ptr.filename), filepath.Base(ptr.filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	complete := false
+	defer func() {
+		if !complete {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	var size int64
+	buf := make([]byte, ptr.width)
+	stride, width := int64(ptr.bytesPerValue), int64(ptr.width)
+
+	err = ptr.forEachValue(func(offset int64, value uint64) error {
+		if !keep(offset, int64(value)) {
+			return nil
+		}
+
+		position := offset * stride
+		ptr.encode(buf, value)
+		if _, err := tmp.WriteAt(buf, position); err != nil {
+			return err
+		}
+		size = position + width
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tmp.Truncate(size); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, ptr.filename); err != nil {
+		return err
+	}
+	complete = true
+
+	file, err := os.OpenFile(ptr.filename, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	ptr.file.Close()
+	ptr.file = file
+	return nil
+}
+
+type fileStorageRecord struct {
+	Offset int64  `json:"offset"`
+	Value  uint64 `json:"value"`
+}
+
+/*
+Dump - writing all storage values as JSON array of {"offset", "value"} objects
+*/
+func (ptr *FileStorage) Dump(w io.Writer) error {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	if ptr.file == nil {
+		return errors.New("file is not open")
+	}
+
+	records := []fileStorageRecord{}
+	err := ptr.forEachValue(func(offset int64, value uint64) error {
+		records = append(records, fileStorageRecord{
+			Offset: offset,
+			Value:  value,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(records)
+}
+
+/*
+Load - writing values from JSON produced by Dump into storage
+*/
+func (ptr *FileStorage) Load(r io.Reader) error {
+	var records []fileStorageRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return fmt.Errorf("can't decode storage JSON, %v", err)
+	}
+
+	for _, record := range records {
+		if err := ptr.SetValue(record.Value, record.Offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
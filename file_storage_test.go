@@ -0,0 +1,247 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// обёртка файла, записывающая и читающая не больше limit байт за вызов
+type shortFile struct {
+	storageFile
+	limit int
+}
+
+func (ptr *shortFile) WriteAt(p []byte, off int64) (int, error) {
+	if len(p) > ptr.limit {
+		p = p[:ptr.limit]
+	}
+	return ptr.storageFile.WriteAt(p, off)
+}
+
+func (ptr *shortFile) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > ptr.limit {
+		p = p[:ptr.limit]
+	}
+	return ptr.storageFile.ReadAt(p, off)
+}
+
+func newTestFileStorage(t *testing.T) *FileStorage {
+	storage := NewFileStorage(filepath.Join(t.TempDir(), "values"), 8, binary.LittleEndian)
+	if err := storage.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Stop() })
+	return storage
+}
+
+func TestFileStorageShortWrite(t *testing.T) {
+	storage := newTestFileStorage(t)
+	storage.file = &shortFile{storageFile: storage.file, limit: 3}
+
+	err := storage.SetValue(42, 0)
+	if err == nil || !strings.Contains(err.Error(), "short write") {
+		t.Fatalf("SetValue() error = %v, expected short write", err)
+	}
+}
+
+func TestFileStorageShortRead(t *testing.T) {
+	storage := newTestFileStorage(t)
+	if err := storage.SetValue(42, 0); err != nil {
+		t.Fatal(err)
+	}
+	storage.file = &shortFile{storageFile: storage.file, limit: 3}
+
+	_, err := storage.GetValue(0)
+	if err == nil || !strings.Contains(err.Error(), "short read") {
+		t.Fatalf("GetValue() error = %v, expected short read", err)
+	}
+}
+
+func TestFileStorageRoundTrip(t *testing.T) {
+	storage := newTestFileStorage(t)
+	if err := storage.SetValue(42, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := storage.GetValue(3); err != nil || value != 42 {
+		t.Fatalf("GetValue(3) = %d, %v", value, err)
+	}
+	// не записанные значения внутри и за концом файла читаются как 0
+	for _, offset := range []int64{1, 10} {
+		if value, err := storage.GetValue(offset); err != nil || value != 0 {
+			t.Fatalf("GetValue(%d) = %d, %v", offset, value, err)
+		}
+	}
+}
+
+func TestFileStorageSnapshotRestore(t *testing.T) {
+	storage := newTestFileStorage(t)
+	for offset, value := range []uint64{5, 0, 7, 9} {
+		if err := storage.SetValue(value, int64(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot := filepath.Join(t.TempDir(), "snapshot")
+	if err := storage.Snapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	// запись после снимка не должна попасть в восстановленное хранилище
+	if err := storage.SetValue(100, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestFileStorage(t)
+	if err := restored.SetValue(1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := restored.Len(); err != nil || count != 4 {
+		t.Fatalf("Len() = %d, %v, expected 4", count, err)
+	}
+	for offset, expected := range []uint64{5, 0, 7, 9} {
+		if value, err := restored.GetValue(int64(offset)); err != nil || value != expected {
+			t.Fatalf("GetValue(%d) = %d, %v, expected %d", offset, value, err, expected)
+		}
+	}
+}
+
+func TestFileStorageRewrite(t *testing.T) {
+	storage := newTestFileStorage(t)
+	for offset, value := range []uint64{3, 0, 4, 0, 0} {
+		if err := storage.SetValue(value, int64(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := storage.Rewrite(func(offset int64, value int64) bool {
+		return value != 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// нули в конце файла отбрасываются, внутри файла остаются дырами
+	if count, err := storage.Len(); err != nil || count != 3 {
+		t.Fatalf("Len() = %d, %v, expected 3", count, err)
+	}
+
+	kept := map[int64]int64{}
+	err = storage.ForEach(func(offset int64, value int64) error {
+		if value != 0 {
+			kept[offset] = value
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(kept, map[int64]int64{0: 3, 2: 4}) {
+		t.Fatalf("values after Rewrite() = %v", kept)
+	}
+
+	// хранилище остаётся доступным для записи после замены файла
+	if err := storage.SetValue(8, 1); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := storage.GetValue(1); err != nil || value != 8 {
+		t.Fatalf("GetValue(1) = %d, %v", value, err)
+	}
+}
+
+func TestFileStorageDumpLoad(t *testing.T) {
+	storage := newTestFileStorage(t)
+	for offset, value := range []uint64{11, 0, 13} {
+		if err := storage.SetValue(value, int64(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := storage.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newTestFileStorage(t)
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := loaded.Len(); err != nil || count != 3 {
+		t.Fatalf("Len() = %d, %v, expected 3", count, err)
+	}
+	for offset, expected := range []uint64{11, 0, 13} {
+		if value, err := loaded.GetValue(int64(offset)); err != nil || value != expected {
+			t.Fatalf("GetValue(%d) = %d, %v, expected %d", offset, value, err, expected)
+		}
+	}
+
+	if err := loaded.Load(strings.NewReader("{")); err == nil {
+		t.Fatal("Load() of invalid JSON returned no error")
+	}
+}
+
+func TestFileStorageConcurrentAccess(t *testing.T) {
+	storage := newTestFileStorage(t)
+	const writers, count = 4, 100
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < count; i += writers {
+				if err := storage.SetValue(uint64(i+1), int64(i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				// значение ещё может быть не записано, но не может быть записано частично
+				if value, err := storage.GetValue(int64(i)); err != nil || (value != 0 && value != uint64(i+1)) {
+					t.Errorf("GetValue(%d) = %d, %v", i, value, err)
+					return
+				}
+				if _, err := storage.Len(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	// Snapshot и Rewrite заменяют файл, пока другие горутины читают и пишут
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		snapshot := filepath.Join(t.TempDir(), "snapshot")
+		for i := 0; i < 10; i++ {
+			if err := storage.Snapshot(snapshot); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := storage.Rewrite(func(int64, int64) bool { return true }); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	for i := 0; i < count; i++ {
+		if value, err := storage.GetValue(int64(i)); err != nil || value != uint64(i+1) {
+			t.Fatalf("GetValue(%d) = %d, %v, expected %d", i, value, err, i+1)
+		}
+	}
+}
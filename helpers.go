@@ -1,18 +1,32 @@
-package common
+package common // import "github.com/KKirillM/common"
 
 import (
+	"cmp"
 	"context"
+	"encoding"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/big"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
 )
 
+// при path == "-" конфиг читается из стандартного ввода
 func LoadConfigFile(path string) (string, error) {
 
+	if path == "-" {
+		return LoadConfigReader(os.Stdin)
+	}
+
 	if len(path) == 0 {
 		applicaitonName, err := os.Executable()
 		if err != nil {
@@ -34,25 +48,329 @@ func LoadConfigFile(path string) (string, error) {
 	return string(content), nil
 }
 
-func BaseCurrency(symbol string) string {
-	symbols := strings.Split(symbol, "/")
-	if len(symbols) != 2 {
-		return ""
+func LoadConfigReader(r io.Reader) (string, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("can't read config, %v", err)
+	}
+
+	return string(content), nil
+}
+
+/*
+ApplyEnvOverrides - overriding fields of decoded config v (pointer to struct) by environment variables
+named in `env:"NAME"` struct tags. Nested structs and slices of structs are processed recursively,
+empty variables leave field intact. Supported types: strings, booleans, numbers, time.Duration ("1m30s"),
+Duration in the same formats as in JSON ("1m30s" or number of seconds), string slices (comma separated)
+and types implementing encoding.TextUnmarshaler
+*/
+func ApplyEnvOverrides(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return errors.New("can't apply env overrides, pointer to struct expected")
+	}
+	return applyEnvOverrides(value.Elem())
+}
+
+func applyEnvOverrides(value reflect.Value) error {
+	valueType := value.Type()
+
+	for i := 0; i < value.NumField(); i++ {
+		field, fieldType := value.Field(i), valueType.Field(i)
+		if !fieldType.IsExported() {
+			continue
+		}
+
+		if name, ok := fieldType.Tag.Lookup("env"); ok && len(name) > 0 {
+			if env := os.Getenv(name); len(env) > 0 {
+				if err := setFieldFromString(field, env); err != nil {
+					return fmt.Errorf("can't apply env %s to field %s, %v", name, fieldType.Name, err)
+				}
+				continue
+			}
+		}
+
+		if err := applyNestedEnvOverrides(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// вложенные структуры, в том числе по указателю и элементы слайсов структур
+func applyNestedEnvOverrides(field reflect.Value) error {
+	switch {
+	case field.Kind() == reflect.Struct:
+		return applyEnvOverrides(field)
+	case field.Kind() == reflect.Ptr && !field.IsNil() && field.Elem().Kind() == reflect.Struct:
+		return applyEnvOverrides(field.Elem())
+	case field.Kind() == reflect.Slice:
+		for i := 0; i < field.Len(); i++ {
+			if err := applyNestedEnvOverrides(field.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	configDurationType  = reflect.TypeOf(Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func setFieldFromString(field reflect.Value, str string) error {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return setFieldFromString(field.Elem(), str)
+	}
+
+	if field.Addr().Type().Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(str))
+	}
+
+	switch field.Type() {
+	case durationType:
+		duration, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	case configDurationType:
+		// как в JSON: строка в формате time.ParseDuration или количество секунд
+		duration, err := time.ParseDuration(str)
+		if err != nil {
+			seconds, floatErr := strconv.ParseFloat(str, 64)
+			if floatErr != nil {
+				return err
+			}
+			duration = time.Duration(math.Round(seconds * float64(time.Second)))
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(str)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		field.SetBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err := strconv.ParseInt(str, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, err := strconv.ParseUint(str, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(value)
+	case reflect.Float32, reflect.Float64:
+		value, err := strconv.ParseFloat(str, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(value)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return errors.New("unsupported slice type " + field.Type().String())
+		}
+		items := strings.Split(str, ",")
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			slice.Index(i).SetString(strings.TrimSpace(item))
+		}
+		field.Set(slice)
+	default:
+		return errors.New("unsupported type " + field.Type().String())
+	}
+
+	return nil
+}
+
+/*
+AtomicWriteFile - writing data to temporary file in the same directory, syncing it and renaming over path,
+so readers never see partially written file
+*/
+func AtomicWriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	complete := false
+	defer func() {
+		if !complete {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
 	}
-	return symbols[0]
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	complete = true
+	return nil
+}
+
+func BaseCurrency(symbol string) string {
+	base, _, _ := SplitSymbol(symbol, "/")
+	return base
 }
 
 func QuoteCurrency(symbol string) string {
-	symbols := strings.Split(symbol, "/")
+	_, quote, _ := SplitSymbol(symbol, "/")
+	return quote
+}
+
+// ok == false, если символ не состоит ровно из двух частей, разделённых sep
+func SplitSymbol(symbol, sep string) (base, quote string, ok bool) {
+	if len(sep) == 0 {
+		return "", "", false
+	}
+
+	symbols := strings.Split(symbol, sep)
 	if len(symbols) != 2 {
-		return ""
+		return "", "", false
+	}
+	return symbols[0], symbols[1], true
+}
+
+/*
+NormalizeSymbol - converting symbol in exchange format ("btc-usd", "BTC_USD", "BTCUSD") to canonical "BTC/USD".
+If raw has no separator, it is split by the known quote suffix (or base prefix).
+Returns empty string if symbol can't be parsed
+*/
+func NormalizeSymbol(raw, base, quote string) string {
+	raw = strings.ToUpper(strings.TrimSpace(raw))
+	base = strings.ToUpper(base)
+	quote = strings.ToUpper(quote)
+
+	separators := []string{"/", "-", "_", ":"}
+	for _, sep := range separators {
+		if parts := strings.Split(raw, sep); len(parts) == 2 {
+			if len(parts[0]) == 0 || len(parts[1]) == 0 {
+				return ""
+			}
+			return parts[0] + "/" + parts[1]
+		}
 	}
-	return symbols[1]
+	// символ с разделителями, не делящийся ровно на две части (например "BTC-USD-PERP"), не разбирается по префиксу
+	for _, sep := range separators {
+		if strings.Contains(raw, sep) {
+			return ""
+		}
+	}
+
+	switch {
+	case len(quote) > 0 && len(raw) > len(quote) && strings.HasSuffix(raw, quote):
+		return strings.TrimSuffix(raw, quote) + "/" + quote
+	case len(base) > 0 && len(raw) > len(base) && strings.HasPrefix(raw, base):
+		return base + "/" + strings.TrimPrefix(raw, base)
+	}
+
+	return ""
 }
 
-func SliceUnion(a, b []string) (c []string) {
+// изменение от prev к next в процентах относительно модуля prev
+func PercentChange(prev, next float64) (float64, error) {
+	if prev == 0 {
+		return 0, errors.New("can't compute percent change from zero value")
+	}
+	return (next - prev) / math.Abs(prev) * 100, nil
+}
 
-	m := make(map[string]bool)
+/*
+RoundToDecimals - rounding value to decimals digits after point, ties are rounded to even (banker's rounding).
+Rounding is done on the shortest decimal representation of value, so 1.005 is treated as exactly 1.005
+*/
+func RoundToDecimals(value float64, decimals int) float64 {
+	return roundDecimals(value, decimals, true)
+}
+
+// отбрасывание лишних знаков после запятой (округление к нулю)
+func TruncateToDecimals(value float64, decimals int) float64 {
+	return roundDecimals(value, decimals, false)
+}
+
+// округлённое значение с фиксированным количеством знаков после запятой для отображения
+func FormatDecimals(value float64, decimals int) string {
+	if decimals < 0 {
+		decimals = 0
+	}
+	return strconv.FormatFloat(RoundToDecimals(value, decimals), 'f', decimals, 64)
+}
+
+func roundDecimals(value float64, decimals int, halfEven bool) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	if decimals < 0 {
+		decimals = 0
+	}
+
+	str := strconv.FormatFloat(math.Abs(value), 'f', -1, 64)
+	intPart, fracPart := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		intPart, fracPart = str[:i], str[i+1:]
+	}
+	if len(fracPart) <= decimals {
+		return value
+	}
+
+	digits, ok := new(big.Int).SetString(intPart+fracPart[:decimals], 10)
+	if !ok {
+		return value
+	}
+
+	if halfEven {
+		next, rest := fracPart[decimals], strings.TrimRight(fracPart[decimals+1:], "0")
+		if next > '5' || (next == '5' && (len(rest) > 0 || digits.Bit(0) == 1)) {
+			digits.Add(digits, big.NewInt(1))
+		}
+	}
+
+	// результат собирается из строки, а не делением на степень 10, чтобы получить ближайшее к десятичному значение
+	text := digits.String()
+	if len(text) <= decimals {
+		text = strings.Repeat("0", decimals-len(text)+1) + text
+	}
+	result, _ := strconv.ParseFloat(text[:len(text)-decimals]+"."+text[len(text)-decimals:], 64)
+
+	if value < 0 {
+		result = -result
+	}
+	return result
+}
+
+func SliceUnion[T comparable](a, b []T) (c []T) {
+
+	m := make(map[T]bool)
 
 	for _, item := range a {
 		m[item] = true
@@ -68,9 +386,9 @@ func SliceUnion(a, b []string) (c []string) {
 	return
 }
 
-func SliceIntersection(a, b []string) (c []string) {
+func SliceIntersection[T comparable](a, b []T) (c []T) {
 
-	m := make(map[string]struct{})
+	m := make(map[T]struct{})
 
 	for _, item := range a {
 		m[item] = struct{}{}
@@ -85,9 +403,9 @@ func SliceIntersection(a, b []string) (c []string) {
 	return
 }
 
-func SliceDifference(a, b []string) (c []string) {
+func SliceDifference[T comparable](a, b []T) (c []T) {
 
-	m := make(map[string]struct{})
+	m := make(map[T]struct{})
 
 	for _, item := range a {
 		m[item] = struct{}{}
@@ -102,6 +420,150 @@ func SliceDifference(a, b []string) (c []string) {
 	return
 }
 
+func SliceContains[T comparable](s []T, v T) bool {
+	for _, item := range s {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// дубликаты удаляются с сохранением порядка первого вхождения
+func SliceUnique[T comparable](s []T) []T {
+	m := make(map[T]struct{}, len(s))
+	result := make([]T, 0, len(s))
+	for _, item := range s {
+		if _, ok := m[item]; !ok {
+			m[item] = struct{}{}
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func SliceMap[T, U any](s []T, f func(T) U) []U {
+	return Map(s, f)
+}
+
+// added - элементы next, отсутствующие в prev, removed - элементы prev, отсутствующие в next
+func SliceDiff[T comparable](prev, next []T) (added, removed []T) {
+
+	m := make(map[T]bool, len(prev))
+
+	for _, item := range prev {
+		m[item] = false
+	}
+
+	for _, item := range next {
+		if _, ok := m[item]; ok {
+			m[item] = true
+		} else {
+			added = append(added, item)
+		}
+	}
+
+	for _, item := range prev {
+		if !m[item] {
+			removed = append(removed, item)
+		}
+	}
+
+	return
+}
+
+// разбиение слайса на части размером не более size, части ссылаются на исходный массив,
+// при size <= 0 весь слайс возвращается одной частью
+func Chunk[T any](s []T, size int) [][]T {
+	if len(s) == 0 {
+		return [][]T{}
+	}
+	if size <= 0 {
+		return [][]T{s[:len(s):len(s)]}
+	}
+
+	chunks := make([][]T, 0, (len(s)+size-1)/size)
+	for start := 0; start < len(s); start += size {
+		end := start + size
+		if end > len(s) {
+			end = len(s)
+		}
+		chunks = append(chunks, s[start:end:end])
+	}
+
+	return chunks
+}
+
+func Filter[T any](s []T, pred func(T) bool) []T {
+	result := make([]T, 0, len(s))
+	for _, item := range s {
+		if pred(item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func Map[T, U any](s []T, f func(T) U) []U {
+	result := make([]U, 0, len(s))
+	for _, item := range s {
+		result = append(result, f(item))
+	}
+	return result
+}
+
+// в результат попадают значения, для которых f вернула true
+func FilterMap[T, U any](s []T, f func(T) (U, bool)) []U {
+	result := make([]U, 0, len(s))
+	for _, item := range s {
+		if value, ok := f(item); ok {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// разворот слайса на месте
+func Reverse[T any](s []T) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+// при выходе индекса за границы возвращает нулевое значение и false
+func SafeGet[T any](s []T, i int) (T, bool) {
+	if i < 0 || i >= len(s) {
+		var zero T
+		return zero, false
+	}
+	return s[i], true
+}
+
+func MapKeys[K comparable, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func MapValues[K comparable, V any](m map[K]V) []V {
+	values := make([]V, 0, len(m))
+	for _, value := range m {
+		values = append(values, value)
+	}
+	return values
+}
+
+// ключи в порядке возрастания, в отличие от MapKeys порядок не зависит от обхода map
+func SortedMapKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := MapKeys(m)
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
 func IsContextCancelled(ctx context.Context) bool {
 	select {
 	case <-ctx.Done():
@@ -111,6 +573,25 @@ func IsContextCancelled(ctx context.Context) bool {
 	}
 }
 
+// контекст отменяется при отмене любого из родителей, значения и дедлайн наследуются от a
+func MergeContexts(a, b context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(a)
+	stop := context.AfterFunc(b, cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// ошибка, если у контекста нет дедлайна, для точек входа, которые не должны выполняться без таймаута
+func RequireDeadline(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("context has no deadline")
+	}
+	return nil
+}
+
 func SleepWithContext(ctx context.Context, duration time.Duration) bool {
 	timer := time.NewTimer(duration)
 	defer timer.Stop()
@@ -123,15 +604,6 @@ func SleepWithContext(ctx context.Context, duration time.Duration) bool {
 	}
 }
 
-func StopCurrentProcess() {
-	// for Linux version
-	//syscall.Kill(os.Getgid(), syscall.SIGINT)
-	// for Windows version
-	pid := os.Getpid()
-	p, _ := os.FindProcess(pid)
-	p.Signal(syscall.SIGTERM)
-}
-
 func TerminateCurrentProcess(reason string) {
 	log.Fatal("F> terminating current process, reason: " + reason)
 }
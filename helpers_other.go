@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package common
+
+import "os"
+
+// отправка os.Interrupt текущему процессу, на платформах без сигналов процесса (js, wasip1) не выполняется
+func StopCurrentProcess() {
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		p.Signal(os.Interrupt)
+	}
+}
@@ -0,0 +1,396 @@
+package common
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSymbol(t *testing.T) {
+	cases := []struct {
+		raw, base, quote, expected string
+	}{
+		{"btc-usd", "", "", "BTC/USD"},
+		{"BTC_USD", "", "", "BTC/USD"},
+		{"BTCUSD", "", "USD", "BTC/USD"},
+		{"BTCUSDT", "BTC", "", "BTC/USDT"},
+		{"BTC-USD-PERP", "BTC", "USD", ""},
+		{"-USD", "", "", ""},
+		{"BTC", "", "USD", ""},
+	}
+
+	for _, c := range cases {
+		if result := NormalizeSymbol(c.raw, c.base, c.quote); result != c.expected {
+			t.Errorf("NormalizeSymbol(%q, %q, %q) = %q, expected %q", c.raw, c.base, c.quote, result, c.expected)
+		}
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	type nested struct {
+		Address string `env:"TEST_NODE_ADDRESS"`
+	}
+	config := struct {
+		Address  string        `env:"TEST_NODE_ADDRESS"`
+		Port     int           `env:"TEST_NODE_PORT"`
+		Debug    bool          `env:"TEST_DEBUG"`
+		Timeout  Duration      `env:"TEST_TIMEOUT"`
+		Interval Duration      `env:"TEST_INTERVAL"`
+		Delay    time.Duration `env:"TEST_DELAY"`
+		Hosts    []string      `env:"TEST_HOSTS"`
+		Name     string        `env:"TEST_EMPTY"`
+		Nested   *nested
+		Items    []nested
+	}{
+		Address: "json",
+		Name:    "json",
+		Nested:  &nested{},
+		Items:   []nested{{}, {}},
+	}
+
+	t.Setenv("TEST_NODE_ADDRESS", "10.0.0.1")
+	t.Setenv("TEST_NODE_PORT", "8080")
+	t.Setenv("TEST_DEBUG", "true")
+	t.Setenv("TEST_TIMEOUT", "1m30s")
+	t.Setenv("TEST_INTERVAL", "2.5")
+	t.Setenv("TEST_DELAY", "250ms")
+	t.Setenv("TEST_HOSTS", "a, b")
+	t.Setenv("TEST_EMPTY", "")
+
+	if err := ApplyEnvOverrides(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Address != "10.0.0.1" || config.Port != 8080 || !config.Debug {
+		t.Fatalf("scalars not overridden: %+v", config)
+	}
+	if config.Timeout.Duration() != 90*time.Second || config.Interval.Duration() != 2500*time.Millisecond || config.Delay != 250*time.Millisecond {
+		t.Fatalf("durations not overridden: %v %v %v", config.Timeout.Duration(), config.Interval.Duration(), config.Delay)
+	}
+	if !reflect.DeepEqual(config.Hosts, []string{"a", "b"}) {
+		t.Fatalf("Hosts = %q", config.Hosts)
+	}
+	if config.Name != "json" {
+		t.Fatalf("empty env overrode value: %q", config.Name)
+	}
+	if config.Nested.Address != "10.0.0.1" || config.Items[0].Address != "10.0.0.1" || config.Items[1].Address != "10.0.0.1" {
+		t.Fatalf("nested structs not overridden: %+v %+v", config.Nested, config.Items)
+	}
+
+	if err := ApplyEnvOverrides(config); err == nil {
+		t.Fatal("non-pointer accepted")
+	}
+
+	t.Setenv("TEST_TIMEOUT", "soon")
+	if err := ApplyEnvOverrides(&config); err == nil {
+		t.Fatal("invalid duration accepted")
+	}
+}
+
+func TestChunk(t *testing.T) {
+	cases := []struct {
+		items    []int
+		size     int
+		expected [][]int
+	}{
+		{[]int{1, 2, 3, 4}, 2, [][]int{{1, 2}, {3, 4}}},
+		{[]int{1, 2, 3, 4, 5}, 2, [][]int{{1, 2}, {3, 4}, {5}}},
+		{[]int{1, 2, 3}, 10, [][]int{{1, 2, 3}}},
+		{[]int{1, 2, 3}, 0, [][]int{{1, 2, 3}}},
+		{[]int{1, 2, 3}, -1, [][]int{{1, 2, 3}}},
+		{nil, 2, [][]int{}},
+	}
+
+	for _, c := range cases {
+		if chunks := Chunk(c.items, c.size); !reflect.DeepEqual(chunks, c.expected) {
+			t.Errorf("Chunk(%v, %d) = %v, expected %v", c.items, c.size, chunks, c.expected)
+		}
+	}
+
+	// добавление в часть не перезаписывает следующую часть
+	items := []int{1, 2, 3, 4}
+	chunks := Chunk(items, 2)
+	_ = append(chunks[0], 100)
+	if items[2] != 3 {
+		t.Fatalf("append to chunk overwrote next chunk: %v", items)
+	}
+}
+
+func TestSliceDiff(t *testing.T) {
+	cases := []struct {
+		name           string
+		prev, next     []string
+		added, removed []string
+	}{
+		{"additions only", []string{"a"}, []string{"a", "b", "c"}, []string{"b", "c"}, nil},
+		{"removals only", []string{"a", "b", "c"}, []string{"b"}, nil, []string{"a", "c"}},
+		{"both", []string{"a", "b"}, []string{"b", "c"}, []string{"c"}, []string{"a"}},
+		{"equal", []string{"a", "b"}, []string{"b", "a"}, nil, nil},
+		{"empty", nil, nil, nil, nil},
+	}
+
+	for _, c := range cases {
+		added, removed := SliceDiff(c.prev, c.next)
+		if !reflect.DeepEqual(added, c.added) || !reflect.DeepEqual(removed, c.removed) {
+			t.Errorf("%s: added %v, removed %v, expected %v, %v", c.name, added, removed, c.added, c.removed)
+		}
+	}
+}
+
+func TestPercentChange(t *testing.T) {
+	cases := []struct {
+		prev, next, expected float64
+	}{
+		{100, 110, 10},
+		{100, 50, -50},
+		{-100, -50, 50},
+		{-100, -150, -50},
+		{50, 50, 0},
+	}
+
+	for _, c := range cases {
+		change, err := PercentChange(c.prev, c.next)
+		if err != nil || math.Abs(change-c.expected) > 1e-9 {
+			t.Errorf("PercentChange(%v, %v) = %v, %v, expected %v", c.prev, c.next, change, err, c.expected)
+		}
+	}
+
+	if _, err := PercentChange(0, 10); err == nil {
+		t.Error("PercentChange from zero succeeded")
+	}
+}
+
+func TestMapKeysValues(t *testing.T) {
+	m := map[string]int{"b": 2, "c": 3, "a": 1}
+
+	keys := MapKeys(m)
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Errorf("MapKeys() = %v", keys)
+	}
+
+	values := MapValues(m)
+	sort.Ints(values)
+	if !reflect.DeepEqual(values, []int{1, 2, 3}) {
+		t.Errorf("MapValues() = %v", values)
+	}
+
+	for i := 0; i < 10; i++ {
+		if keys := SortedMapKeys(m); !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+			t.Fatalf("SortedMapKeys() = %v", keys)
+		}
+	}
+	if keys := SortedMapKeys(map[int]bool{10: true, -1: true, 3: true}); !reflect.DeepEqual(keys, []int{-1, 3, 10}) {
+		t.Errorf("SortedMapKeys() of ints = %v", keys)
+	}
+
+	empty := map[string]int{}
+	if len(MapKeys(empty)) != 0 || len(MapValues(empty)) != 0 || len(SortedMapKeys(empty)) != 0 {
+		t.Error("non-empty result for empty map")
+	}
+	if MapKeys(empty) == nil || MapValues(empty) == nil {
+		t.Error("nil slice for empty map")
+	}
+}
+
+func TestFilterMap(t *testing.T) {
+	even := func(v int) bool { return v%2 == 0 }
+	double := func(v int) string { return strconv.Itoa(v * 2) }
+
+	if result := Filter([]int{1, 2, 3, 4}, even); !reflect.DeepEqual(result, []int{2, 4}) {
+		t.Errorf("Filter() = %v", result)
+	}
+	if result := Map([]int{1, 2}, double); !reflect.DeepEqual(result, []string{"2", "4"}) {
+		t.Errorf("Map() = %v", result)
+	}
+	result := FilterMap([]int{1, 2, 3, 4}, func(v int) (string, bool) { return double(v), even(v) })
+	if !reflect.DeepEqual(result, []string{"4", "8"}) {
+		t.Errorf("FilterMap() = %v", result)
+	}
+
+	if result := Filter(nil, even); result == nil || len(result) != 0 {
+		t.Errorf("Filter(nil) = %#v", result)
+	}
+	if result := Map(nil, double); result == nil || len(result) != 0 {
+		t.Errorf("Map(nil) = %#v", result)
+	}
+	if result := FilterMap([]int{}, func(v int) (int, bool) { return v, true }); result == nil || len(result) != 0 {
+		t.Errorf("FilterMap(empty) = %#v", result)
+	}
+}
+
+func TestReverseSafeGet(t *testing.T) {
+	for _, c := range [][2][]int{
+		{{1, 2, 3}, {3, 2, 1}},
+		{{1, 2}, {2, 1}},
+		{{1}, {1}},
+		{{}, {}},
+	} {
+		s := append([]int{}, c[0]...)
+		if Reverse(s); !reflect.DeepEqual(s, c[1]) {
+			t.Errorf("Reverse(%v) = %v", c[0], s)
+		}
+	}
+	Reverse([]int(nil))
+
+	s := []string{"a", "b"}
+	cases := []struct {
+		index int
+		value string
+		ok    bool
+	}{
+		{0, "a", true},
+		{1, "b", true},
+		{2, "", false},
+		{-1, "", false},
+	}
+	for _, c := range cases {
+		if value, ok := SafeGet(s, c.index); value != c.value || ok != c.ok {
+			t.Errorf("SafeGet(%d) = %q, %v", c.index, value, ok)
+		}
+	}
+	if _, ok := SafeGet([]string(nil), 0); ok {
+		t.Error("SafeGet on empty slice succeeded")
+	}
+}
+
+func TestRoundToDecimals(t *testing.T) {
+	cases := []struct {
+		value           float64
+		decimals        int
+		round, truncate float64
+	}{
+		{1.005, 2, 1.0, 1.0},
+		{1.015, 2, 1.02, 1.01},
+		{1.0051, 2, 1.01, 1.0},
+		{2.5, 0, 2, 2},
+		{3.5, 0, 4, 3},
+		{-2.5, 0, -2, -2},
+		{-1.235, 2, -1.24, -1.23},
+		{-0.129, 2, -0.13, -0.12},
+		{0.1 + 0.2, 2, 0.3, 0.3},
+		{123.456, 5, 123.456, 123.456},
+		{0.00015, 4, 0.0002, 0.0001},
+		{7.9, -1, 8, 7},
+	}
+
+	for _, c := range cases {
+		if result := RoundToDecimals(c.value, c.decimals); result != c.round {
+			t.Errorf("RoundToDecimals(%v, %d) = %v, expected %v", c.value, c.decimals, result, c.round)
+		}
+		if result := TruncateToDecimals(c.value, c.decimals); result != c.truncate {
+			t.Errorf("TruncateToDecimals(%v, %d) = %v, expected %v", c.value, c.decimals, result, c.truncate)
+		}
+	}
+
+	if text := FormatDecimals(1.5, 3); text != "1.500" {
+		t.Errorf("FormatDecimals(1.5, 3) = %q", text)
+	}
+	if text := FormatDecimals(-0.125, 2); text != "-0.12" {
+		t.Errorf("FormatDecimals(-0.125, 2) = %q", text)
+	}
+	if !math.IsNaN(RoundToDecimals(math.NaN(), 2)) || !math.IsInf(TruncateToDecimals(math.Inf(1), 2), 1) {
+		t.Error("NaN or Inf changed by rounding")
+	}
+}
+
+func TestRequireDeadline(t *testing.T) {
+	if err := RequireDeadline(context.Background()); err == nil {
+		t.Error("context without deadline accepted")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := RequireDeadline(ctx); err != nil {
+		t.Errorf("context with timeout: %v", err)
+	}
+
+	// дедлайн наследуется производными контекстами
+	child, cancelChild := context.WithCancel(ctx)
+	defer cancelChild()
+	if err := RequireDeadline(child); err != nil {
+		t.Errorf("child context: %v", err)
+	}
+}
+
+func TestMergeContexts(t *testing.T) {
+	waitDone := func(ctx context.Context) bool {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+
+	for _, cancelFirst := range []bool{true, false} {
+		a, cancelA := context.WithCancel(context.Background())
+		b, cancelB := context.WithCancel(context.Background())
+
+		ctx, cancel := MergeContexts(a, b)
+		if IsContextCancelled(ctx) {
+			t.Fatal("merged context cancelled before parents")
+		}
+
+		if cancelFirst {
+			cancelA()
+		} else {
+			cancelB()
+		}
+		if !waitDone(ctx) {
+			t.Fatalf("merged context not cancelled after cancelling parent (first %v)", cancelFirst)
+		}
+
+		cancel()
+		cancelA()
+		cancelB()
+	}
+
+	// значения наследуются от первого родителя, cancel отменяет только объединённый контекст
+	type key struct{}
+	a := context.WithValue(context.Background(), key{}, "a")
+	b, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+
+	ctx, cancel := MergeContexts(a, b)
+	if ctx.Value(key{}) != "a" {
+		t.Fatal("value of first parent lost")
+	}
+	cancel()
+	if !IsContextCancelled(ctx) || IsContextCancelled(b) {
+		t.Fatal("cancel func affected parent or didn't cancel merged context")
+	}
+}
+
+func TestSliceSetOperations(t *testing.T) {
+	a, b := []int{1, 2, 3}, []int{2, 3, 4}
+	if c := SliceUnion(a, b); !reflect.DeepEqual(c, []int{1, 2, 3, 4}) {
+		t.Errorf("SliceUnion(%v, %v) = %v", a, b, c)
+	}
+	if c := SliceIntersection(a, b); !reflect.DeepEqual(c, []int{2, 3}) {
+		t.Errorf("SliceIntersection(%v, %v) = %v", a, b, c)
+	}
+	// элементы b, отсутствующие в a
+	if c := SliceDifference(a, b); !reflect.DeepEqual(c, []int{4}) {
+		t.Errorf("SliceDifference(%v, %v) = %v", a, b, c)
+	}
+
+	type pair struct {
+		base, quote string
+	}
+	x := []pair{{"BTC", "USDT"}, {"ETH", "USDT"}}
+	y := []pair{{"ETH", "USDT"}, {"ETH", "BTC"}}
+	if c := SliceUnion(x, y); !reflect.DeepEqual(c, []pair{{"BTC", "USDT"}, {"ETH", "USDT"}, {"ETH", "BTC"}}) {
+		t.Errorf("SliceUnion(%v, %v) = %v", x, y, c)
+	}
+	if c := SliceIntersection(x, y); !reflect.DeepEqual(c, []pair{{"ETH", "USDT"}}) {
+		t.Errorf("SliceIntersection(%v, %v) = %v", x, y, c)
+	}
+	if c := SliceDifference(x, y); !reflect.DeepEqual(c, []pair{{"ETH", "BTC"}}) {
+		t.Errorf("SliceDifference(%v, %v) = %v", x, y, c)
+	}
+}
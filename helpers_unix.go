@@ -0,0 +1,10 @@
+//go:build unix
+
+package common
+
+import "syscall"
+
+// отправка SIGINT текущему процессу для штатного завершения через обработчик сигналов
+func StopCurrentProcess() {
+	syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+}
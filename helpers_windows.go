@@ -0,0 +1,14 @@
+//go:build windows
+
+package common
+
+import (
+	"os"
+	"syscall"
+)
+
+// отправка SIGTERM текущему процессу для штатного завершения через обработчик сигналов
+func StopCurrentProcess() {
+	p, _ := os.FindProcess(os.Getpid())
+	p.Signal(syscall.SIGTERM)
+}
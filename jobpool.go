@@ -3,15 +3,43 @@
 package common
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"runtime"
+	"runtime/debug"
 	"sync"
 )
 
+// User handler of panics raised by jobs, shared by all workers of the pool
+type panicHandler struct {
+	mu      sync.RWMutex
+	handler func(interface{})
+}
+
+func (h *panicHandler) set(handler func(interface{})) {
+	h.mu.Lock()
+	h.handler = handler
+	h.mu.Unlock()
+}
+
+func (h *panicHandler) call(value interface{}) {
+	h.mu.RLock()
+	handler := h.handler
+	h.mu.RUnlock()
+
+	if handler != nil {
+		handler(value)
+	}
+}
+
 // Gorouting instance which can accept client jobs
 type worker struct {
 	workerPool chan *worker
 	jobChannel chan Job
 	stop       chan struct{}
+	onPanic    *panicHandler
 }
 
 func (w *worker) start() {
@@ -23,7 +51,7 @@ func (w *worker) start() {
 
 			select {
 			case job = <-w.jobChannel:
-				job()
+				w.run(job)
 			case <-w.stop:
 				w.stop <- struct{}{}
 				return
@@ -32,11 +60,24 @@ func (w *worker) start() {
 	}()
 }
 
-func newWorker(pool chan *worker) *worker {
+// Recovers job panic, so the worker keeps running and returns to the pool
+func (w *worker) run(job Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("E> job panicked: %v\n%s", r, debug.Stack())
+			w.onPanic.call(r)
+		}
+	}()
+
+	job()
+}
+
+func newWorker(pool chan *worker, onPanic *panicHandler) *worker {
 	return &worker{
 		workerPool: pool,
 		jobChannel: make(chan Job),
 		stop:       make(chan struct{}),
+		onPanic:    onPanic,
 	}
 }
 
@@ -67,7 +108,7 @@ func (d *dispatcher) dispatch() {
 	}
 }
 
-func newDispatcher(workerPool chan *worker, jobQueue chan Job) *dispatcher {
+func newDispatcher(workerPool chan *worker, jobQueue chan Job, onPanic *panicHandler) *dispatcher {
 	d := &dispatcher{
 		workerPool: workerPool,
 		jobQueue:   jobQueue,
@@ -75,7 +116,7 @@ func newDispatcher(workerPool chan *worker, jobQueue chan Job) *dispatcher {
 	}
 
 	for i := 0; i < cap(d.workerPool); i++ {
-		worker := newWorker(d.workerPool)
+		worker := newWorker(d.workerPool, onPanic)
 		worker.start()
 	}
 
@@ -90,6 +131,10 @@ type JobPool struct {
 	JobQueue   chan Job
 	dispatcher *dispatcher
 	wg         sync.WaitGroup
+	onPanic    *panicHandler
+	// held for reading while a job is being enqueued, so Release waits for pending AddJob calls
+	mu       sync.RWMutex
+	released bool
 }
 
 // Will make pool of gorouting workers.
@@ -102,23 +147,40 @@ func NewJobPool(jobQueueLen int) *JobPool {
 	if jobQueueLen < numWorkers {
 		numWorkers = jobQueueLen
 	}
+	return NewJobPoolWithWorkers(jobQueueLen, numWorkers)
+}
+
+// Will make pool with exactly numWorkers workers (at least one),
+// useful for IO-bound jobs where workers count should exceed CPU count.
+func NewJobPoolWithWorkers(jobQueueLen, numWorkers int) *JobPool {
 	// if one core processor :)
-	if numWorkers == 0 {
+	if numWorkers < 1 {
 		numWorkers = 1
 	}
 
 	jobQueue := make(chan Job, jobQueueLen)
 	workerPool := make(chan *worker, numWorkers)
 
+	onPanic := &panicHandler{}
+
 	pool := &JobPool{
 		JobQueue:   jobQueue,
-		dispatcher: newDispatcher(workerPool, jobQueue),
+		dispatcher: newDispatcher(workerPool, jobQueue, onPanic),
+		onPanic:    onPanic,
 	}
 
 	return pool
 }
 
+// Panics if the pool is already released, use TryAddJob to get an error instead.
 func (p *JobPool) AddJob(job Job) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.released {
+		panic("job pool: AddJob called after Release")
+	}
+
 	p.wg.Add(1)
 	p.JobQueue <- func() {
 		defer p.wg.Done()
@@ -126,13 +188,116 @@ func (p *JobPool) AddJob(job Job) {
 	}
 }
 
+// Same as AddJob, but stops waiting for free place in the queue when ctx is done
+// and returns ctx.Err() in this case.
+func (p *JobPool) AddJobCtx(ctx context.Context, job Job) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.released {
+		return errors.New("job pool is released")
+	}
+
+	p.wg.Add(1)
+	select {
+	case p.JobQueue <- func() {
+		defer p.wg.Done()
+		job()
+	}:
+		return nil
+	case <-ctx.Done():
+		p.wg.Done()
+		return ctx.Err()
+	}
+}
+
+// Adds job without blocking, returns error if the pool is released or the queue is full.
+func (p *JobPool) TryAddJob(job Job) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.released {
+		return errors.New("job pool is released")
+	}
+
+	p.wg.Add(1)
+	select {
+	case p.JobQueue <- func() {
+		defer p.wg.Done()
+		job()
+	}:
+		return nil
+	default:
+		p.wg.Done()
+		return errors.New("job pool queue is full")
+	}
+}
+
+// Sets handler called with the value of panic raised by any job.
+// Panics are always recovered and logged, the worker keeps serving jobs.
+func (p *JobPool) OnPanic(handler func(interface{})) {
+	p.onPanic.set(handler)
+}
+
+// Submits one job per input and waits for them, errors are indexed to match inputs.
+// A panic in fn is recovered and returned as the error of that input.
+func RunJobs[T any](pool *JobPool, inputs []T, fn func(T) error) []error {
+	errs := make([]error, len(inputs))
+
+	var wg sync.WaitGroup
+	wg.Add(len(inputs))
+
+	for i, input := range inputs {
+		func(index int, value T) {
+			pool.AddJob(func() {
+				defer wg.Done()
+				defer func() {
+					if r := recover(); r != nil {
+						errs[index] = fmt.Errorf("job %d panicked: %v\n%s", index, r, debug.Stack())
+					}
+				}()
+				errs[index] = fn(value)
+			})
+		}(i, input)
+	}
+
+	// waits only for own jobs, unlike WaitAll which waits for all jobs of the pool
+	wg.Wait()
+	return errs
+}
+
+// Metrics below are lock-free point-in-time snapshots, the values may change right after the call.
+
+// Number of jobs waiting in the queue.
+func (p *JobPool) QueueLen() int {
+	return len(p.JobQueue)
+}
+
+// Capacity of the jobs queue.
+func (p *JobPool) QueueCap() int {
+	return cap(p.JobQueue)
+}
+
+// Number of workers not waiting in the pool for a job, i.e. executing or receiving one.
+func (p *JobPool) BusyWorkers() int {
+	return cap(p.dispatcher.workerPool) - len(p.dispatcher.workerPool)
+}
+
 // Will wait for all jobs to finish.
 func (p *JobPool) WaitAll() {
 	p.wg.Wait()
 }
 
-// Will release resources used by pool
+// Will release resources used by pool, subsequent calls do nothing
 func (p *JobPool) Release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.released {
+		return
+	}
+	p.released = true
+
 	p.dispatcher.stop <- struct{}{}
 	<-p.dispatcher.stop
 }
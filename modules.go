@@ -1,12 +1,19 @@
 package common
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -16,12 +23,18 @@ type ModuleConfig struct {
 	Disable        bool            `json:"disable"`
 	TasksQueueSize int             `json:"tasks_queue_size"`
 	Params         json.RawMessage `json:"params"`
+	// модули, которые должны быть запущены до этого модуля
+	DependsOn []string `json:"depends_on,omitempty"`
+	// ограничение времени запуска и остановки модуля, 0 - без ограничения
+	StartTimeout Duration `json:"start_timeout,omitempty"`
+	StopTimeout  Duration `json:"stop_timeout,omitempty"`
 }
 
 type ModuleServerConfig struct {
 	Modules []ModuleConfig `json:"modules"`
 }
 
+// после декодирования поля с тегом env переопределяются переменными окружения, см. ApplyEnvOverrides
 func ParseModuleServerConfig(config string) (*ModuleServerConfig, error) {
 	cfg := ModuleServerConfig{}
 
@@ -29,9 +42,128 @@ func ParseModuleServerConfig(config string) (*ModuleServerConfig, error) {
 		return nil, fmt.Errorf("can't decode config JSON, %v", err)
 	}
 
+	if err := ApplyEnvOverrides(&cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+type ParseOptions struct {
+	// ошибка при наличии в конфиге неизвестных полей
+	DisallowUnknownFields bool
+}
+
+// после декодирования поля с тегом env переопределяются переменными окружения, см. ApplyEnvOverrides
+func ParseModuleServerConfigWithOptions(config string, opts ParseOptions) (*ModuleServerConfig, error) {
+	cfg := ModuleServerConfig{}
+
+	decoder := json.NewDecoder(strings.NewReader(config))
+	if opts.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("can't decode config JSON, %v", err)
+	}
+	// Decode читает только первое значение, данные после него json.Unmarshal считает ошибкой
+	if decoder.More() {
+		return nil, errors.New("can't decode config JSON, unexpected data after top-level value")
+	}
+
+	if err := ApplyEnvOverrides(&cfg); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
 
+/*
+MergeJSON - recursively merging override into base: objects are merged, override values win,
+arrays and scalars are replaced, null values delete keys from base
+*/
+func MergeJSON(base, override json.RawMessage) (json.RawMessage, error) {
+	if len(override) == 0 {
+		return base, nil
+	}
+	if len(base) == 0 {
+		return override, nil
+	}
+
+	var baseValue, overrideValue interface{}
+
+	if err := decodeJSONValue(base, &baseValue); err != nil {
+		return nil, fmt.Errorf("can't decode base JSON, %v", err)
+	}
+	if err := decodeJSONValue(override, &overrideValue); err != nil {
+		return nil, fmt.Errorf("can't decode override JSON, %v", err)
+	}
+
+	merged, err := json.Marshal(mergeJSONValues(baseValue, overrideValue))
+	if err != nil {
+		return nil, fmt.Errorf("can't encode merged JSON, %v", err)
+	}
+
+	return merged, nil
+}
+
+// числа декодируются в json.Number, чтобы не терять точность больших целых
+func decodeJSONValue(data json.RawMessage, value *interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
+
+func mergeJSONValues(base, override interface{}) interface{} {
+	baseObject, ok := base.(map[string]interface{})
+	if !ok {
+		return override
+	}
+	overrideObject, ok := override.(map[string]interface{})
+	if !ok {
+		return override
+	}
+
+	for key, value := range overrideObject {
+		if value == nil {
+			delete(baseObject, key)
+			continue
+		}
+		baseItem, ok := baseObject[key]
+		if !ok {
+			// вложенные null в новом объекте также не должны попадать в результат
+			baseItem = map[string]interface{}{}
+		}
+		baseObject[key] = mergeJSONValues(baseItem, value)
+	}
+
+	return baseObject
+}
+
+func (cfg *ModuleServerConfig) String() string {
+	data, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+/*
+Save - writing effective config to the file as indented JSON
+*/
+func (cfg *ModuleServerConfig) Save(path string) error {
+	data, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return fmt.Errorf("can't encode config JSON, %v", err)
+	}
+
+	if err := AtomicWriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("can't save config file: %s, %v", path, err)
+	}
+
+	return nil
+}
+
 type IModule interface {
 	LoadConfig(config json.RawMessage) error
 	Start() error
@@ -49,28 +181,38 @@ type IModule interface {
 // - завершить работу всего приложения (в случае критической ошибки)
 type IServer interface {
 	Ctx() context.Context
+	ModuleCtx(moduleID string) context.Context
 	CallModule(moduleID string, msgType int, data interface{}) error
-	RestartModule(moduleID string, reason string, timeout time.Duration)
+	RestartModule(moduleID string, reason string, timeout time.Duration) error
 	Terminate(module IModule, reason string, timeout time.Duration)
 }
 
 type ModuleCreator func(IServer, string, string, int) (IModule, error)
 
 type ModuleServer struct {
-	ctx           context.Context
-	cancelCtx     context.CancelFunc
-	mu            sync.Mutex
-	modules       map[string]IModule
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+	// защищает modules, не удерживается во время запуска и остановки модулей,
+	// чтобы модули могли вызывать CallModule из Start/Stop
+	mu      sync.RWMutex
+	modules map[string]IModule
+	// конфигурации загруженных модулей
+	configs map[string]ModuleConfig
+	// контексты модулей, производные от ctx сервера, отменяются при остановке модуля
+	moduleContexts map[string]moduleContext
+	// исключает одновременное выполнение Start, Stop, AddModule и RemoveModule
+	lifecycleMu sync.Mutex
+	// Start выполнен, а Stop ещё нет
+	running       bool
 	moduleCreator ModuleCreator
-	//interruptChan chan os.Signal
 }
 
 func NewModuleServer(creator ModuleCreator) *ModuleServer {
 	srv := ModuleServer{
-		mu:            sync.Mutex{},
-		modules:       make(map[string]IModule),
-		moduleCreator: creator,
-		//interruptChan: make(chan os.Signal, 1),
+		modules:        make(map[string]IModule),
+		configs:        make(map[string]ModuleConfig),
+		moduleContexts: make(map[string]moduleContext),
+		moduleCreator:  creator,
 	}
 
 	srv.ctx, srv.cancelCtx = context.WithCancel(context.Background())
@@ -82,10 +224,80 @@ func (ptr *ModuleServer) Ctx() context.Context {
 	return ptr.ctx
 }
 
+type moduleContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+/*
+ModuleCtx - context of the module, it is cancelled when the module is stopped, restarted or removed
+and when the server stops. Modules should return it from IModule.Ctx()
+*/
+func (ptr *ModuleServer) ModuleCtx(id string) context.Context {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	if moduleCtx, ok := ptr.moduleContexts[id]; ok {
+		return moduleCtx.ctx
+	}
+	return ptr.ctx
+}
+
+// новый контекст модуля перед запуском, предыдущий контекст отменяется
+func (ptr *ModuleServer) resetModuleCtx(id string) {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	if moduleCtx, ok := ptr.moduleContexts[id]; ok {
+		moduleCtx.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(ptr.ctx)
+	ptr.moduleContexts[id] = moduleContext{ctx: ctx, cancel: cancel}
+}
+
+func (ptr *ModuleServer) cancelModuleCtx(id string) {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	if moduleCtx, ok := ptr.moduleContexts[id]; ok {
+		moduleCtx.cancel()
+		delete(ptr.moduleContexts, id)
+	}
+}
+
 func (ptr *ModuleServer) Wait() <-chan struct{} {
 	return ptr.ctx.Done()
 }
 
+/*
+WaitForSignal - blocking until one of signals is received (SIGINT and SIGTERM by default)
+or server context is done, then stopping all modules
+*/
+func (ptr *ModuleServer) WaitForSignal(signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+
+	interruptChan := make(chan os.Signal, 1)
+	signal.Notify(interruptChan, signals...)
+	defer signal.Stop(interruptChan)
+
+	select {
+	case sig := <-interruptChan:
+		log.Println("I> signal " + sig.String() + " received, stopping modules")
+	case <-ptr.ctx.Done():
+	}
+
+	if err := ptr.Stop(); err != nil {
+		log.Println("E> some modules stop failed: " + err.Error())
+	}
+}
+
+/*
+LoadConfig - creating and configuring enabled modules from config, modules are registered only
+if all of them are created and configured successfully and their dependencies are valid
+*/
 func (ptr *ModuleServer) LoadConfig(config *ModuleServerConfig) ([]string, error) {
 
 	mLen := len(config.Modules)
@@ -93,60 +305,79 @@ func (ptr *ModuleServer) LoadConfig(config *ModuleServerConfig) ([]string, error
 		return nil, errors.New("there are no any modules in config")
 	}
 
+	// зависимости проверяются до создания модулей вместе с уже зарегистрированными модулями
+	configs := make(map[string]ModuleConfig)
+	ptr.mu.RLock()
+	for id, cfg := range ptr.configs {
+		configs[id] = cfg
+	}
+	ptr.mu.RUnlock()
+
 	modulesList := make([]string, 0, mLen)
 
 	for _, cfg := range config.Modules {
 		if cfg.Disable {
 			continue
 		}
+		if _, ok := configs[cfg.ID]; ok {
+			return nil, errors.New("module " + cfg.ID + " already exists")
+		}
+		configs[cfg.ID] = cfg
+		modulesList = append(modulesList, cfg.ID)
+	}
+
+	if _, err := buildDependencyLevels(configs); err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string]IModule, len(modulesList))
+
+	for _, id := range modulesList {
+		cfg := configs[id]
 
 		newModule, err := ptr.moduleCreator(ptr, cfg.Type, cfg.ID, cfg.TasksQueueSize)
 		if err != nil {
 			return nil, errors.New("creation module " + cfg.ID + " failed, " + err.Error())
 		}
 
-		ptr.modules[cfg.ID] = newModule
-
 		if err := newModule.LoadConfig(cfg.Params); err != nil {
 			return nil, errors.New("loading config for module " + cfg.ID + " failed, " + err.Error())
 		}
 
-		modulesList = append(modulesList, cfg.ID)
+		modules[id] = newModule
+	}
+
+	ptr.mu.Lock()
+	for id, module := range modules {
+		ptr.modules[id] = module
+		ptr.configs[id] = configs[id]
 	}
+	ptr.mu.Unlock()
 
 	return modulesList, nil
 }
 
 func (ptr *ModuleServer) Start() error {
-	pool := NewJobPool(len(ptr.modules))
-	errorsQueue := make(chan error, len(ptr.modules))
+	ptr.lifecycleMu.Lock()
+	defer ptr.lifecycleMu.Unlock()
 
-	ptr.mu.Lock()
-
-	for id, md := range ptr.modules {
-		func(moduleID string, module IModule) {
-			pool.AddJob(func() {
-				errorsQueue <- ptr.startModule(moduleID, module)
-			})
-		}(id, md)
+	modules := ptr.modulesSnapshot()
+	levels, err := ptr.dependencyLevels(modules)
+	if err != nil {
+		return err
 	}
-	pool.WaitAll()
-	pool.Release()
-
-	ptr.mu.Unlock()
-
-	close(errorsQueue)
 
 	var errList string
-	for err := range errorsQueue {
-		if err != nil {
-			if len(errList) > 0 {
-				errList += ", "
-			}
-			errList += "[" + err.Error() + "]"
+	for _, level := range levels {
+		errList = joinErrList(errList, ptr.executeForModules(level, modules, ptr.startModule))
+		// модули следующих уровней зависят от модулей текущего, поэтому при ошибке не запускаются
+		if len(errList) > 0 {
+			break
 		}
 	}
 
+	ptr.running = true
+
 	if len(errList) > 0 {
 		return errors.New(errList)
 	}
@@ -157,43 +388,282 @@ func (ptr *ModuleServer) Start() error {
 func (ptr *ModuleServer) Stop() error {
 	ptr.cancelCtx()
 
-	pool := NewJobPool(len(ptr.modules))
-	errorsQueue := make(chan error, len(ptr.modules))
+	ptr.lifecycleMu.Lock()
+	defer ptr.lifecycleMu.Unlock()
+
+	ptr.running = false
+
+	modules := ptr.modulesSnapshot()
+	levels, err := ptr.dependencyLevels(modules)
+	if err != nil {
+		return err
+	}
+
+	// остановка в порядке обратном запуску: сначала зависимые модули
+	var errList string
+	for i := len(levels) - 1; i >= 0; i-- {
+		errList = joinErrList(errList, ptr.executeForModules(levels[i], modules, ptr.stopModule))
+	}
+
+	if len(errList) > 0 {
+		return errors.New(errList)
+	}
+
+	return nil
+}
+
+/*
+AddModule - creating, configuring and registering new module at runtime,
+the module is started immediately if the server is already running
+*/
+func (ptr *ModuleServer) AddModule(cfg ModuleConfig) error {
+	ptr.lifecycleMu.Lock()
+	defer ptr.lifecycleMu.Unlock()
+
+	if _, ok := ptr.GetModule(cfg.ID); ok {
+		return errors.New("module " + cfg.ID + " already exists")
+	}
+
+	for _, dependency := range cfg.DependsOn {
+		if _, ok := ptr.GetModule(dependency); !ok {
+			return errors.New("module " + cfg.ID + " depends on unknown module " + dependency)
+		}
+	}
+
+	newModule, err := ptr.moduleCreator(ptr, cfg.Type, cfg.ID, cfg.TasksQueueSize)
+	if err != nil {
+		return errors.New("creation module " + cfg.ID + " failed, " + err.Error())
+	}
+
+	if err := newModule.LoadConfig(cfg.Params); err != nil {
+		return errors.New("loading config for module " + cfg.ID + " failed, " + err.Error())
+	}
 
 	ptr.mu.Lock()
+	ptr.modules[cfg.ID] = newModule
+	ptr.configs[cfg.ID] = cfg
+	ptr.mu.Unlock()
+
+	if !ptr.running {
+		return nil
+	}
+
+	if err := ptr.startModule(cfg.ID, newModule); err != nil {
+		ptr.unregisterModule(cfg.ID)
+		return err
+	}
+
+	return nil
+}
+
+/*
+RemoveModule - stopping started module and unregistering it,
+modules that other modules depend on can't be removed
+*/
+func (ptr *ModuleServer) RemoveModule(id string) error {
+	ptr.lifecycleMu.Lock()
+	defer ptr.lifecycleMu.Unlock()
+
+	module, ok := ptr.GetModule(id)
+	if !ok {
+		return errors.New("module " + id + " not found")
+	}
+
+	if dependentID, ok := ptr.findDependent(id); ok {
+		return errors.New("module " + dependentID + " depends on module " + id)
+	}
+
+	if module != nil && module.IsStarted() {
+		if err := ptr.stopModule(id, module); err != nil {
+			return err
+		}
+	}
+
+	ptr.unregisterModule(id)
+	return nil
+}
+
+func (ptr *ModuleServer) findDependent(id string) (string, bool) {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	for dependentID, cfg := range ptr.configs {
+		if SliceContains(cfg.DependsOn, id) {
+			return dependentID, true
+		}
+	}
+	return "", false
+}
 
-	for id, md := range ptr.modules {
+func (ptr *ModuleServer) unregisterModule(id string) {
+	ptr.cancelModuleCtx(id)
+
+	ptr.mu.Lock()
+	delete(ptr.modules, id)
+	delete(ptr.configs, id)
+	ptr.mu.Unlock()
+}
+
+// параллельное выполнение action для модулей ids, возвращает список ошибок в виде строки
+func (ptr *ModuleServer) executeForModules(ids []string, modules map[string]IModule, action func(string, IModule) error) string {
+	pool := NewJobPool(len(ids))
+	errorsQueue := make(chan error, len(ids))
+
+	for _, id := range ids {
 		func(moduleID string, module IModule) {
 			pool.AddJob(func() {
-				errorsQueue <- ptr.stopModule(moduleID, module)
+				errorsQueue <- action(moduleID, module)
 			})
-		}(id, md)
+		}(id, modules[id])
 	}
 	pool.WaitAll()
 	pool.Release()
 
-	ptr.mu.Unlock()
-
 	close(errorsQueue)
 
 	var errList string
 	for err := range errorsQueue {
 		if err != nil {
-			if len(errList) > 0 {
-				errList += ", "
-			}
-			errList += "[" + err.Error() + "]"
+			errList = joinErrList(errList, "["+err.Error()+"]")
 		}
 	}
 
+	return errList
+}
+
+func joinErrList(errList, errs string) string {
+	if len(errs) == 0 {
+		return errList
+	}
 	if len(errList) > 0 {
-		return errors.New(errList)
+		errList += ", "
 	}
+	return errList + errs
+}
 
-	return nil
+/*
+dependencyLevels - splitting modules into levels by declared dependencies,
+modules of each level depend only on modules of previous levels and can be started in parallel
+*/
+func (ptr *ModuleServer) dependencyLevels(modules map[string]IModule) ([][]string, error) {
+	ptr.mu.RLock()
+	configs := make(map[string]ModuleConfig, len(modules))
+	for id := range modules {
+		configs[id] = ptr.configs[id]
+	}
+	ptr.mu.RUnlock()
+
+	return buildDependencyLevels(configs)
+}
+
+// configs - конфигурации всех модулей по их ID, зависимости вне configs считаются ошибкой
+func buildDependencyLevels(configs map[string]ModuleConfig) ([][]string, error) {
+	dependsCount := make(map[string]int, len(configs))
+	dependents := make(map[string][]string)
+
+	for id, cfg := range configs {
+		dependsCount[id] = 0
+		for _, dependency := range cfg.DependsOn {
+			if _, ok := configs[dependency]; !ok {
+				return nil, errors.New("module " + id + " depends on unknown module " + dependency)
+			}
+			dependsCount[id]++
+			dependents[dependency] = append(dependents[dependency], id)
+		}
+	}
+
+	var level []string
+	for id, count := range dependsCount {
+		if count == 0 {
+			level = append(level, id)
+		}
+	}
+
+	var levels [][]string
+	placed := 0
+	for len(level) > 0 {
+		sort.Strings(level)
+		levels = append(levels, level)
+		placed += len(level)
+
+		var next []string
+		for _, id := range level {
+			delete(dependsCount, id)
+			for _, dependent := range dependents[id] {
+				dependsCount[dependent]--
+				if dependsCount[dependent] == 0 {
+					next = append(next, dependent)
+				}
+			}
+		}
+		level = next
+	}
+
+	if placed < len(configs) {
+		cycle := SortedMapKeys(dependsCount)
+		return nil, errors.New("dependency cycle detected, modules in or depending on the cycle: " + strings.Join(cycle, ", "))
+	}
+
+	return levels, nil
+}
+
+func (ptr *ModuleServer) modulesSnapshot() map[string]IModule {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	modules := make(map[string]IModule, len(ptr.modules))
+	for id, module := range ptr.modules {
+		modules[id] = module
+	}
+	return modules
+}
+
+type ModuleStatus struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Started bool   `json:"started"`
+}
+
+/*
+Status - snapshot of loaded modules state, returned map is a copy
+*/
+func (ptr *ModuleServer) Status() map[string]ModuleStatus {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	status := make(map[string]ModuleStatus, len(ptr.modules))
+	for id, module := range ptr.modules {
+		if module == nil {
+			status[id] = ModuleStatus{ID: id}
+			continue
+		}
+		status[id] = ModuleStatus{
+			ID:      id,
+			Type:    module.GetType(),
+			Started: module.IsStarted(),
+		}
+	}
+	return status
+}
+
+func (ptr *ModuleServer) GetModule(id string) (IModule, bool) {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+
+	module, ok := ptr.modules[id]
+	return module, ok
+}
+
+// перехват паники модуля, чтобы ошибка одного модуля не завершала весь процесс
+func recoverModulePanic(id string, err *error) {
+	if p := recover(); p != nil {
+		*err = fmt.Errorf("module %s panicked: %v\n%s", id, p, debug.Stack())
+	}
 }
 
-func (ptr *ModuleServer) startModule(id string, module IModule) error {
+func (ptr *ModuleServer) startModule(id string, module IModule) (err error) {
+	defer recoverModulePanic(id, &err)
+
 	if module == nil {
 		return errors.New("module " + id + " is nil")
 	}
@@ -202,10 +672,14 @@ func (ptr *ModuleServer) startModule(id string, module IModule) error {
 		return errors.New("module " + id + " already started")
 	}
 
-	return module.Start()
+	ptr.resetModuleCtx(id)
+
+	return ptr.executeWithTimeout(id, "start", ptr.moduleConfig(id).StartTimeout.Duration(), module.Start)
 }
 
-func (ptr *ModuleServer) stopModule(id string, module IModule) error {
+func (ptr *ModuleServer) stopModule(id string, module IModule) (err error) {
+	defer recoverModulePanic(id, &err)
+
 	if module == nil {
 		return errors.New("module " + id + " is nil")
 	}
@@ -214,11 +688,49 @@ func (ptr *ModuleServer) stopModule(id string, module IModule) error {
 		return errors.New("module " + id + " already stopped")
 	}
 
-	return module.Stop()
+	ptr.cancelModuleCtx(id)
+
+	return ptr.executeWithTimeout(id, "stop", ptr.moduleConfig(id).StopTimeout.Duration(), module.Stop)
 }
 
-func (ptr *ModuleServer) CallModule(id string, msgType int, data interface{}) error {
-	module, ok := ptr.modules[id]
+func (ptr *ModuleServer) moduleConfig(id string) ModuleConfig {
+	ptr.mu.RLock()
+	defer ptr.mu.RUnlock()
+	return ptr.configs[id]
+}
+
+// при timeout <= 0 ожидание завершения fn не ограничено
+func (ptr *ModuleServer) executeWithTimeout(id string, action string, timeout time.Duration, fn func() error) error {
+	if timeout <= 0 {
+		return fn()
+	}
+
+	result := make(chan error, 1)
+	timedOut := false
+
+	ExecuteWithTimeout(timeout, func() {
+		var err error
+		defer func() {
+			result <- err
+		}()
+		// fn выполняется в отдельной горутине, поэтому паника перехватывается здесь
+		defer recoverModulePanic(id, &err)
+		err = fn()
+	}, func() {
+		timedOut = true
+	})
+
+	if timedOut {
+		return errors.New("module " + id + " " + action + " timeout " + timeout.String() + " reached")
+	}
+
+	return <-result
+}
+
+func (ptr *ModuleServer) CallModule(id string, msgType int, data interface{}) (err error) {
+	defer recoverModulePanic(id, &err)
+
+	module, ok := ptr.GetModule(id)
 	if !ok {
 		return errors.New("module " + id + " not found")
 	}
@@ -230,22 +742,66 @@ func (ptr *ModuleServer) CallModule(id string, msgType int, data interface{}) er
 	return module.DataHandler(module.Ctx(), msgType, data)
 }
 
-func (ptr *ModuleServer) RestartModule(id string, reason string, timeout time.Duration) {
+/*
+BroadcastData - sending data to all started modules in parallel,
+returns map of module ID to the error returned by its DataHandler (nil on success)
+*/
+func (ptr *ModuleServer) BroadcastData(msgType int, data interface{}) map[string]error {
+	var ids []string
+	for id, module := range ptr.modulesSnapshot() {
+		if module != nil && module.IsStarted() {
+			ids = append(ids, id)
+		}
+	}
+
+	results := make(map[string]error, len(ids))
+	if len(ids) == 0 {
+		return results
+	}
+
+	var mu sync.Mutex
+	pool := NewJobPool(len(ids))
+
+	for _, id := range ids {
+		func(moduleID string) {
+			pool.AddJob(func() {
+				err := ptr.CallModule(moduleID, msgType, data)
+				mu.Lock()
+				results[moduleID] = err
+				mu.Unlock()
+			})
+		}(id)
+	}
+	pool.WaitAll()
+	pool.Release()
+
+	return results
+}
+
+/*
+RestartModule - stopping and starting the module again, stop and start errors are returned to the caller,
+which decides whether to continue or terminate the process. If the module is not started after timeout,
+the process is terminated
+*/
+func (ptr *ModuleServer) RestartModule(id string, reason string, timeout time.Duration) error {
 	log.Println("W> module " + id + " requested a restart, reason: " + reason)
 
-	module, ok := ptr.modules[id]
+	module, ok := ptr.GetModule(id)
 	if !ok {
 		log.Println("E> module " + id + " not found")
+		return errors.New("module " + id + " not found")
 	}
 
+	ptr.cancelModuleCtx(id)
+
 	if err := module.Stop(); err != nil {
-		TerminateCurrentProcess("module '" + module.GetID() + "' stop failed: " + err.Error())
-		return
+		return errors.New("module " + id + " stop failed, " + err.Error())
 	}
 
+	ptr.resetModuleCtx(id)
+
 	if err := module.Start(); err != nil {
-		TerminateCurrentProcess("module '" + module.GetID() + "' start failed: " + err.Error())
-		return
+		return errors.New("module " + id + " start failed, " + err.Error())
 	}
 
 	go func() {
@@ -254,6 +810,8 @@ func (ptr *ModuleServer) RestartModule(id string, reason string, timeout time.Du
 			TerminateCurrentProcess("timeout " + timeout.String() + " reached while restarting")
 		}
 	}()
+
+	return nil
 }
 
 func (ptr *ModuleServer) Terminate(module IModule, reason string, timeout time.Duration) {
@@ -265,11 +823,13 @@ func (ptr *ModuleServer) Terminate(module IModule, reason string, timeout time.D
 	}
 
 	TerminateCurrentProcess("all modules stopped correctly")
+}
 
-	go func() {
-		time.Sleep(timeout)
-		if !module.IsStarted() {
-			TerminateCurrentProcess("timeout " + timeout.String() + " reached while stopping")
-		}
-	}()
+/*
+Shutdown - stopping all modules and returning aggregated error,
+unlike Terminate the process keeps running and the caller decides whether to exit
+*/
+func (ptr *ModuleServer) Shutdown(reason string) error {
+	log.Println("W> shutdown requested, reason: " + reason)
+	return ptr.Stop()
 }
@@ -0,0 +1,317 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseModuleServerConfigWithOptions(t *testing.T) {
+	cases := []struct {
+		config string
+		opts   ParseOptions
+		valid  bool
+	}{
+		{`{"modules":[{"id":"a","type":"t"}]}`, ParseOptions{DisallowUnknownFields: true}, true},
+		{`{"modules":[]}` + "\n", ParseOptions{DisallowUnknownFields: true}, true},
+		{`{"modules":[],"bogus":1}`, ParseOptions{DisallowUnknownFields: true}, false},
+		{`{"modules":[],"bogus":1}`, ParseOptions{}, true},
+		{`{"modules":[]} {"bogus":1}`, ParseOptions{DisallowUnknownFields: true}, false},
+		{`{"modules":[]} {"bogus":1}`, ParseOptions{}, false},
+	}
+
+	for _, c := range cases {
+		_, err := ParseModuleServerConfigWithOptions(c.config, c.opts)
+		if (err == nil) != c.valid {
+			t.Errorf("config %s with %+v: error %v, expected valid %v", c.config, c.opts, err, c.valid)
+		}
+	}
+}
+
+type testModule struct {
+	server  IServer
+	id      string
+	started int32
+	calls   int64
+	// ошибка, возвращаемая из Start
+	startErr error
+}
+
+func newTestModule(server IServer, moduleType, id string, _ int) (IModule, error) {
+	return &testModule{server: server, id: id}, nil
+}
+
+func (ptr *testModule) LoadConfig(json.RawMessage) error { return nil }
+func (ptr *testModule) Start() error {
+	if ptr.startErr != nil {
+		return ptr.startErr
+	}
+	atomic.StoreInt32(&ptr.started, 1)
+	return nil
+}
+
+func (ptr *testModule) Stop() error          { atomic.StoreInt32(&ptr.started, 0); return nil }
+func (ptr *testModule) GetID() string        { return ptr.id }
+func (ptr *testModule) GetType() string      { return "test" }
+func (ptr *testModule) Ctx() context.Context { return ptr.server.ModuleCtx(ptr.id) }
+func (ptr *testModule) IsStarted() bool      { return atomic.LoadInt32(&ptr.started) == 1 }
+
+func (ptr *testModule) DataHandler(context.Context, int, interface{}) error {
+	atomic.AddInt64(&ptr.calls, 1)
+	return nil
+}
+
+// запускается с -race: доступ к модулям из нескольких горутин во время запуска, добавления и удаления
+func TestModuleServerConcurrentAccess(t *testing.T) {
+	const restartTimeout = 20 * time.Millisecond
+
+	server := NewModuleServer(newTestModule)
+	if _, err := server.LoadConfig(&ModuleServerConfig{Modules: []ModuleConfig{{ID: "a", Type: "test"}, {ID: "b", Type: "test"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// модуль может быть ещё не запущен или уже удалён, важно отсутствие гонок
+				server.CallModule("a", 0, nil)
+				server.CallModule("c", 0, nil)
+				server.BroadcastData(0, nil)
+				server.Status()
+			}
+		}()
+	}
+
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := server.AddModule(ModuleConfig{ID: "c", Type: "test"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.RestartModule("a", "test", restartTimeout); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.RemoveModule("c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	if err := server.CallModule("a", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// проверки перезапуска завершают процесс, если модуль к этому времени не запущен,
+	// поэтому модуль останавливается только после того, как все они выполнены
+	time.Sleep(5 * restartTimeout)
+	if err := server.Shutdown("test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModuleServerLoadConfigDependencies(t *testing.T) {
+	cases := []struct {
+		name    string
+		modules []ModuleConfig
+		valid   bool
+	}{
+		{"ordered", []ModuleConfig{{ID: "db"}, {ID: "consumer", DependsOn: []string{"db"}}}, true},
+		{"unknown", []ModuleConfig{{ID: "consumer", DependsOn: []string{"db"}}}, false},
+		{"disabled dependency", []ModuleConfig{{ID: "db", Disable: true}, {ID: "consumer", DependsOn: []string{"db"}}}, false},
+		{"cycle", []ModuleConfig{{ID: "a", DependsOn: []string{"b"}}, {ID: "b", DependsOn: []string{"a"}}}, false},
+		{"duplicate", []ModuleConfig{{ID: "a"}, {ID: "a"}}, false},
+	}
+
+	for _, c := range cases {
+		created := 0
+		server := NewModuleServer(func(server IServer, moduleType, id string, queueSize int) (IModule, error) {
+			created++
+			return newTestModule(server, moduleType, id, queueSize)
+		})
+
+		_, err := server.LoadConfig(&ModuleServerConfig{Modules: c.modules})
+		if (err == nil) != c.valid {
+			t.Errorf("%s: error %v, expected valid %v", c.name, err, c.valid)
+			continue
+		}
+
+		// при ошибке ни один модуль не создаётся и не регистрируется
+		if !c.valid && (created != 0 || len(server.Status()) != 0) {
+			t.Errorf("%s: %d modules created, %d registered after failed load", c.name, created, len(server.Status()))
+		}
+	}
+}
+
+func TestModuleServerLoadConfigRollback(t *testing.T) {
+	server := NewModuleServer(func(server IServer, moduleType, id string, queueSize int) (IModule, error) {
+		if moduleType == "broken" {
+			return nil, errors.New("unknown type")
+		}
+		return newTestModule(server, moduleType, id, queueSize)
+	})
+
+	_, err := server.LoadConfig(&ModuleServerConfig{Modules: []ModuleConfig{{ID: "a", Type: "test"}, {ID: "b", Type: "broken"}}})
+	if err == nil {
+		t.Fatal("LoadConfig succeeded with broken module")
+	}
+	if _, ok := server.GetModule("a"); ok {
+		t.Fatal("module a registered after failed load")
+	}
+}
+
+func TestRestartModuleReturnsErrors(t *testing.T) {
+	server := NewModuleServer(newTestModule)
+	if _, err := server.LoadConfig(&ModuleServerConfig{Modules: []ModuleConfig{{ID: "a", Type: "test"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.RestartModule("missing", "test", time.Second); err == nil {
+		t.Fatal("restart of missing module succeeded")
+	}
+
+	module, _ := server.GetModule("a")
+	module.(*testModule).startErr = errors.New("port is busy")
+
+	// ошибка запуска возвращается вызывающему коду вместо завершения процесса
+	err := server.RestartModule("a", "test", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "port is busy") {
+		t.Fatalf("RestartModule() error = %v", err)
+	}
+}
+
+func TestModuleServerConfigSaveRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.config.json")
+	initial := `{"modules":[{"id":"db","type":"postgres","params":{"host":"localhost"}}]}`
+	if err := os.WriteFile(path, []byte(initial), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseModuleServerConfig(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Modules[0].StartTimeout = Duration(5 * time.Second)
+	config.Modules = append(config.Modules, ModuleConfig{ID: "consumer", Type: "listener", DependsOn: []string{"db"}})
+	if err := config.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != config.String() {
+		t.Fatalf("saved config differs from String():\n%s\n%s", content, config.String())
+	}
+	reloaded, err := ParseModuleServerConfig(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// params при сохранении форматируются, поэтому сравнивается повторно сохранённый JSON
+	if reloaded.String() != config.String() {
+		t.Fatalf("reloaded config:\n%s\nexpected:\n%s", reloaded, config)
+	}
+	if len(reloaded.Modules) != 2 || reloaded.Modules[0].StartTimeout.Duration() != 5*time.Second || reloaded.Modules[1].DependsOn[0] != "db" {
+		t.Fatalf("modifications lost after reload: %+v", reloaded.Modules)
+	}
+}
+
+func TestLoadConfigReader(t *testing.T) {
+	buf := bytes.NewBufferString(`{"modules":[{"id":"a","type":"t"}]}`)
+
+	content, err := LoadConfigReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseModuleServerConfig(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Modules) != 1 || config.Modules[0].ID != "a" {
+		t.Fatalf("decoded modules %+v", config.Modules)
+	}
+
+	// путь "-" читает конфиг из стандартного ввода
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		r.Close()
+	}()
+
+	w.WriteString(`{"modules":[]}`)
+	w.Close()
+
+	if content, err := LoadConfigFile("-"); err != nil || content != `{"modules":[]}` {
+		t.Fatalf("LoadConfigFile(-) = %q, %v", content, err)
+	}
+}
+
+func TestMergeJSON(t *testing.T) {
+	cases := []struct {
+		name, base, override, expected string
+	}{
+		{"nested", `{"db":{"host":"a","port":1},"name":"x"}`, `{"db":{"port":2}}`, `{"db":{"host":"a","port":2},"name":"x"}`},
+		{"array replaced", `{"hosts":["a","b"]}`, `{"hosts":["c"]}`, `{"hosts":["c"]}`},
+		{"null deletes", `{"db":{"host":"a","port":1},"name":"x"}`, `{"db":{"port":null},"name":null}`, `{"db":{"host":"a"}}`},
+		{"new key", `{"a":1}`, `{"b":{"c":2,"d":null}}`, `{"a":1,"b":{"c":2}}`},
+		{"scalar replaces object", `{"a":{"b":1}}`, `{"a":2}`, `{"a":2}`},
+		{"empty override", `{"a":1}`, ``, `{"a":1}`},
+		{"empty base", ``, `{"a":1}`, `{"a":1}`},
+	}
+
+	for _, c := range cases {
+		merged, err := MergeJSON(json.RawMessage(c.base), json.RawMessage(c.override))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		var result, expected interface{}
+		if err := json.Unmarshal(merged, &result); err != nil {
+			t.Errorf("%s: invalid result %s", c.name, merged)
+			continue
+		}
+		json.Unmarshal([]byte(c.expected), &expected)
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("%s: merged %s, expected %s", c.name, merged, c.expected)
+		}
+	}
+
+	if _, err := MergeJSON(json.RawMessage(`{"a":`), json.RawMessage(`{"b":1}`)); err == nil {
+		t.Error("invalid base JSON accepted")
+	}
+}
@@ -0,0 +1,57 @@
+package pgtest // import "github.com/KKirillM/common/pgtest"
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	common "github.com/KKirillM/common"
+)
+
+var schemaCounter uint64
+
+/*
+NewTestSchema - creating uniquely named schema and setting search_path of every pg connection to it,
+the schema is dropped with all its objects and search_path is restored when the test completes.
+
+Unlike returning cleanup function and panicking, failures are reported through t and cleanup is
+registered by t.Cleanup, so it runs even if the test fails before reaching a deferred call.
+
+search_path is set for the whole pool of pg (see Postgres.SetSearchPath), so parallel tests
+must use separate Postgres instances
+*/
+func NewTestSchema(t testing.TB, ctx context.Context, pg *common.Postgres) string {
+	t.Helper()
+
+	schema := fmt.Sprintf("test_%d_%d", time.Now().UnixNano(), atomic.AddUint64(&schemaCounter, 1))
+
+	if err := execute(ctx, pg, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("creation test schema failed, %v", err)
+	}
+
+	previous := pg.SearchPath()
+	t.Cleanup(func() {
+		if err := pg.SetSearchPath(context.Background(), previous); err != nil {
+			t.Errorf("restoring search_path failed, %v", err)
+		}
+		if err := execute(context.Background(), pg, "DROP SCHEMA IF EXISTS "+schema+" CASCADE"); err != nil {
+			t.Errorf("dropping test schema %s failed, %v", schema, err)
+		}
+	})
+
+	if err := pg.SetSearchPath(ctx, schema); err != nil {
+		t.Fatalf("setting search_path failed, %v", err)
+	}
+
+	return schema
+}
+
+func execute(ctx context.Context, pg *common.Postgres, query string) error {
+	rows, err := pg.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
@@ -0,0 +1,67 @@
+package pgtest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	common "github.com/KKirillM/common"
+)
+
+// PGTEST_CONFIG - DBConfig в формате JSON, без него тесты с базой пропускаются
+func connect(t *testing.T) *common.Postgres {
+	t.Helper()
+
+	data := os.Getenv("PGTEST_CONFIG")
+	if data == "" {
+		t.Skip("PGTEST_CONFIG is not set")
+	}
+
+	config := &common.DBConfig{}
+	if err := json.Unmarshal([]byte(data), config); err != nil {
+		t.Fatalf("parsing PGTEST_CONFIG failed, %v", err)
+	}
+
+	pg := common.NewPostgres()
+	if err := pg.LoadConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := pg.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pg.Close() })
+
+	return pg
+}
+
+func TestNewTestSchemaIsolation(t *testing.T) {
+	for _, name := range []string{"first", "second"} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			pg := connect(t)
+			NewTestSchema(t, ctx, pg)
+
+			// одноимённые таблицы в параллельных тестах не пересекаются, search_path задан для всех соединений пула
+			if err := execute(ctx, pg, "CREATE TABLE items (name text)"); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				if err := execute(ctx, pg, "INSERT INTO items VALUES ('"+name+"')"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var count int
+			if err := pg.QueryRow(ctx, "SELECT count(*) FROM items").Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if count != 10 {
+				t.Fatalf("items has %d rows, expected 10 rows of %s only", count, name)
+			}
+		})
+	}
+}
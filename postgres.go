@@ -3,10 +3,16 @@ package common
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/lib/pq"
@@ -19,6 +25,29 @@ const LOG = "log"
 
 const ERROR = "error"
 
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// максимальное время ожидания обработки текущего уведомления при закрытии
+const listenerCloseTimeout = 10 * time.Second
+
+// интервал пинга listener по умолчанию, если уведомлений нет
+const defaultListenIdleTimeout = 90 * time.Second
+
+// интервалы между попытками переподключения listener, pq увеличивает интервал вдвое после каждой неудачи
+const (
+	listenMinReconnectInterval = 10 * time.Second
+	listenMaxReconnectInterval = time.Minute
+)
+
+// задержка перед первым повтором подключения, если ConnectRetryDelay не задан, и предел её удвоения
+const (
+	defaultConnectRetryDelay = 100 * time.Millisecond
+	maxConnectRetryDelay     = 30 * time.Second
+)
+
+// максимальное количество параметров в одном запросе PostgreSQL
+const maxQueryParams = 65535
+
 type QueryContext struct {
 	Ctx    context.Context
 	Table  string
@@ -32,8 +61,20 @@ type DBConfig struct {
 	Password,
 	Host string
 	Port int
+	// порты для каждого хоста при перечислении нескольких хостов через запятую в Host,
+	// если не заданы, то для всех хостов используется Port
+	Ports []int
 	Database,
 	SSLmode string
+	// ограничение времени выполнения запроса, если у переданного контекста нет дедлайна,
+	// 0 - без ограничения
+	QueryTimeout Duration
+	// количество повторных попыток подключения, 0 - без повторов
+	ConnectRetries int
+	// задержка перед первым повтором (по умолчанию 100ms), удваивается с каждой попыткой, но не более 30s
+	ConnectRetryDelay Duration
+	// search_path всех соединений пула, пустое значение - search_path сервера по умолчанию
+	SearchPath string
 }
 
 type Postgres struct {
@@ -42,19 +83,66 @@ type Postgres struct {
 	listener          *pq.Listener
 	connectionInfo    string
 	listenIdleTimeout time.Duration
-	handler           func(string)
+	handler           func(channel, payload string)
 	errorHandler      func(error)
+	reconnectHandler  func()
+	// удерживается во время обработки уведомления, чтобы Close дождался её завершения
+	handlerMu         sync.Mutex
+	placeholderFormat PlaceholderFormat
+	debug             bool
+	tracer            QueryTracer
+	queryHook         func(query string, args []interface{}, dur time.Duration, err error)
+	queryHookArgs     bool
+	// защищает refs и listener
+	refMu sync.Mutex
+	// количество дополнительных пользователей, полученных через Acquire
+	refs int
+}
+
+// PlaceholderFormat формирует плейсхолдер параметра запроса по его порядковому номеру (начиная с 1) и имени поля
+type PlaceholderFormat func(index int, field string) string
+
+// PositionalDollar - плейсхолдеры PostgreSQL: $1, $2, ...
+func PositionalDollar(index int, _ string) string {
+	return "$" + strconv.Itoa(index)
+}
+
+// QuestionMark - плейсхолдеры вида ?, ?, ...
+func QuestionMark(int, string) string {
+	return "?"
+}
+
+// NamedColon - именованные плейсхолдеры вида :field
+func NamedColon(_ int, field string) string {
+	return ":" + field
 }
 
 func NewPostgres() *Postgres {
-	return &Postgres{}
+	return &Postgres{
+		listenIdleTimeout: defaultListenIdleTimeout,
+	}
 }
 
-func (ptr *Postgres) LoadConfig(config *DBConfig) error {
+// интервал проверки соединения listener пингом при отсутствии уведомлений
+func (ptr *Postgres) SetListenIdleTimeout(timeout time.Duration) {
+	ptr.listenIdleTimeout = timeout
+}
+
+/*
+Validate - checking that all required connection parameters are set
+*/
+func (config *DBConfig) Validate() error {
 	if len(config.Host) == 0 {
 		return errors.New("db config failed, host not found")
 	}
-	if config.Port == 0 {
+	if hosts := config.hosts(); len(hosts) > 1 {
+		if len(config.Ports) == 0 && config.Port == 0 {
+			return errors.New("db config failed, port not found")
+		}
+		if len(config.Ports) != 0 && len(config.Ports) != len(hosts) {
+			return errors.New("db config failed, number of ports does not match number of hosts")
+		}
+	} else if config.Port == 0 && !config.isSocket() {
 		return errors.New("db config failed, port not found")
 	}
 	if len(config.User) == 0 || len(config.Password) == 0 {
@@ -63,21 +151,231 @@ func (ptr *Postgres) LoadConfig(config *DBConfig) error {
 	if len(config.Database) == 0 {
 		return errors.New("db config failed, database name not found")
 	}
+	if len(config.SSLmode) != 0 && !SliceContains(sslModes, config.SSLmode) {
+		return errors.New("db config failed, invalid sslmode " + strconv.Quote(config.SSLmode) +
+			", valid values: " + strings.Join(sslModes, ", "))
+	}
+	return nil
+}
+
+// значения sslmode, поддерживаемые драйвером pq
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full", "prefer", "allow"}
+
+// пустой SSLmode означает disable, иначе в строку подключения попадёт "sslmode=" без значения
+func (config *DBConfig) sslMode() string {
+	if len(config.SSLmode) == 0 {
+		return "disable"
+	}
+	return config.SSLmode
+}
+
+// Host начинающийся с "/" является директорией Unix-сокета PostgreSQL
+func (config *DBConfig) isSocket() bool {
+	return strings.HasPrefix(config.Host, "/")
+}
+
+func (config *DBConfig) hosts() []string {
+	var hosts []string
+	for _, host := range strings.Split(config.Host, ",") {
+		if host = strings.TrimSpace(host); len(host) > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func (config *DBConfig) connectionString() string {
+	connection := config.baseConnectionString()
+	// параметр запуска сервера применяется к каждому соединению пула, в отличие от SET
+	if len(config.SearchPath) > 0 {
+		connection += "&options=" + url.QueryEscape("-c search_path="+config.SearchPath)
+	}
+	return connection
+}
+
+func (config *DBConfig) baseConnectionString() string {
+	if hosts := config.hosts(); len(hosts) > 1 {
+		addresses := make([]string, 0, len(hosts))
+		for i, host := range hosts {
+			port := config.Port
+			if len(config.Ports) != 0 {
+				port = config.Ports[i]
+			}
+			addresses = append(addresses, host+":"+strconv.Itoa(port))
+		}
+
+		return fmt.Sprintf("postgres://%v:%v@%v/%v?sslmode=%v&target_session_attrs=read-write",
+			config.User,
+			config.Password,
+			strings.Join(addresses, ","),
+			config.Database,
+			config.sslMode(),
+		)
+	}
+
+	if config.isSocket() {
+		connection := fmt.Sprintf("postgres://%v:%v@/%v?host=%v&sslmode=%v",
+			config.User,
+			config.Password,
+			config.Database,
+			config.Host,
+			config.sslMode(),
+		)
+		// порт входит в имя файла сокета (.s.PGSQL.<port>)
+		if config.Port != 0 {
+			connection += fmt.Sprintf("&port=%v", config.Port)
+		}
+		return connection
+	}
+
+	return fmt.Sprintf("postgres://%v:%v@%v:%v/%v?sslmode=%v",
+		config.User,
+		config.Password,
+		config.Host,
+		config.Port,
+		config.Database,
+		config.sslMode(),
+	)
+}
+
+/*
+SetPlaceholderFormat - setting placeholders style for generated queries, PositionalDollar is used by default.
+Named formats (NamedColon) bind one value per field, so builders needing several values for the same field
+(SaveBulk, DeleteByKeys, InsertBatch with several rows, Update with condition args) return an error
+*/
+func (ptr *Postgres) SetPlaceholderFormat(format PlaceholderFormat) {
+	ptr.placeholderFormat = format
+}
+
+// QueryTracer - минимальный интерфейс трассировки запросов, позволяющий подключить OpenTelemetry
+// или другую систему без зависимости от неё в этом пакете
+type QueryTracer interface {
+	StartSpan(ctx context.Context, query string) (context.Context, QuerySpan)
+}
+
+type QuerySpan interface {
+	End(duration time.Duration, err error)
+}
+
+/*
+SetTracer - setting tracer creating span for each query executed by Exec and convenience builders, nil disables tracing
+*/
+func (ptr *Postgres) SetTracer(tracer QueryTracer) {
+	ptr.tracer = tracer
+}
+
+/*
+OnQuery - setting hook called after each statement executed by Exec, parameterized load methods and
+convenience builders with query text, elapsed time and error. Args are passed to the hook only after
+SetQueryHookArgs(true), otherwise args is nil so secrets can't leak into logs
+*/
+func (ptr *Postgres) OnQuery(hook func(query string, args []interface{}, dur time.Duration, err error)) {
+	ptr.queryHook = hook
+}
+
+func (ptr *Postgres) SetQueryHookArgs(enabled bool) {
+	ptr.queryHookArgs = enabled
+}
+
+// начало выполнения запроса для трассировки и хука OnQuery, возвращаемая функция вызывается по завершении
+func (ptr *Postgres) startQuery(ctx context.Context, query string, args []interface{}) (context.Context, func(error)) {
+	if ptr.tracer == nil && ptr.queryHook == nil {
+		return ctx, func(error) {}
+	}
+
+	start := time.Now()
+	var span QuerySpan
+	if ptr.tracer != nil {
+		ctx, span = ptr.tracer.StartSpan(ctx, query)
+	}
+
+	return ctx, func(err error) {
+		duration := time.Since(start)
+		if span != nil {
+			span.End(duration, err)
+		}
+		if ptr.queryHook != nil {
+			if !ptr.queryHookArgs {
+				args = nil
+			}
+			ptr.queryHook(query, args, duration, err)
+		}
+	}
+}
+
+/*
+SetDebug - enabling logging of queries generated by Save, SaveBulk, Create, Update and Delete methods.
+Only query text is logged, values are never written to the log
+*/
+func (ptr *Postgres) SetDebug(enabled bool) {
+	ptr.debug = enabled
+}
+
+func (ptr *Postgres) logQuery(query string) {
+	if ptr.debug {
+		log.Println("D> query: " + query)
+	}
+}
+
+func (ptr *Postgres) placeholder(index int, field string) string {
+	if ptr.placeholderFormat == nil {
+		return PositionalDollar(index, field)
+	}
+	return ptr.placeholderFormat(index, field)
+}
+
+// плейсхолдер зависит только от имени поля (как NamedColon), поэтому одно поле нельзя связать с несколькими значениями
+func (ptr *Postgres) namedPlaceholders() bool {
+	return ptr.placeholder(1, "a") == ptr.placeholder(2, "a") && ptr.placeholder(1, "a") != ptr.placeholder(1, "b")
+}
+
+var errNamedPlaceholdersRepeat = errors.New("named placeholders can't bind several values to the same field, use positional placeholder format")
+
+func (ptr *Postgres) LoadConfig(config *DBConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
 	ptr.config = config
 	return nil
 }
 
+/*
+SetSearchPath - setting search_path for every connection of the pool, open pool is closed and reconnected,
+so it must not be called concurrently with queries. Config passed to LoadConfig is not modified
+*/
+func (ptr *Postgres) SetSearchPath(ctx context.Context, searchPath string) error {
+	if ptr.config == nil {
+		return errors.New("db config is not loaded")
+	}
+
+	config := *ptr.config
+	config.SearchPath = searchPath
+	ptr.config = &config
+
+	if ptr.conn == nil {
+		return nil
+	}
+
+	err := ptr.conn.Close()
+	ptr.conn = nil
+	if err != nil {
+		return err
+	}
+
+	return ptr.Connect(ctx)
+}
+
+func (ptr *Postgres) SearchPath() string {
+	if ptr.config == nil {
+		return ""
+	}
+	return ptr.config.SearchPath
+}
+
 func (ptr *Postgres) Connect(ctx context.Context) (err error) {
 
 	if ptr.conn == nil {
-		ptr.connectionInfo = fmt.Sprintf("postgres://%v:%v@%v:%v/%v?sslmode=%v",
-			ptr.config.User,
-			ptr.config.Password,
-			ptr.config.Host,
-			ptr.config.Port,
-			ptr.config.Database,
-			ptr.config.SSLmode,
-		)
+		ptr.connectionInfo = ptr.config.connectionString()
 
 		ptr.conn, err = sql.Open(sqlDriverName, ptr.connectionInfo)
 		if err != nil {
@@ -85,7 +383,33 @@ func (ptr *Postgres) Connect(ctx context.Context) (err error) {
 		}
 	}
 
-	return ptr.conn.PingContext(ctx)
+	delay := ptr.config.ConnectRetryDelay.Duration()
+	if delay <= 0 {
+		delay = defaultConnectRetryDelay
+	}
+	attempts := 0
+	for {
+		attempts++
+		if err = ptr.conn.PingContext(ctx); err == nil {
+			return nil
+		}
+
+		if attempts > ptr.config.ConnectRetries {
+			break
+		}
+
+		if !SleepWithContext(ctx, delay) {
+			break
+		}
+		if delay *= 2; delay > maxConnectRetryDelay {
+			delay = maxConnectRetryDelay
+		}
+	}
+
+	if ptr.config.ConnectRetries == 0 {
+		return err
+	}
+	return fmt.Errorf("connection failed after %d attempts, %w", attempts, err)
 }
 
 /*
@@ -98,12 +422,187 @@ func (ptr *Postgres) Load(ctx context.Context, query string) (*sql.Rows, error)
 
 	rows, err := ptr.Exec(ctx, query)
 	if err != nil {
-		return rows, errors.New(err.Error() + ", query: " + query)
+		return rows, queryError(ctx, err, query)
 	}
 
 	return rows, nil
 }
 
+/*
+LoadArgs - selecting data from DB with positional args instead of building query by hand.
+Args are never added to the error text so they can't leak into logs
+*/
+func (ptr *Postgres) LoadArgs(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if err := ptr.checkConnection(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx, endQuery := ptr.startQuery(ctx, query, args)
+	queryCtx, _ := ptr.queryContext(ctx)
+	rows, err := ptr.conn.QueryContext(queryCtx, query, args...)
+	endQuery(err)
+	if err != nil {
+		return rows, queryError(queryCtx, err, query)
+	}
+
+	return rows, nil
+}
+
+/*
+QueryRow - selecting single row from DB with positional args.
+Like in database/sql, errors are deferred until Scan is called on the returned row
+*/
+func (ptr *Postgres) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	// ошибка подключения повторится при выполнении запроса и будет получена в Scan
+	ptr.checkConnection(ctx)
+
+	// ошибка выполнения станет известна только в Scan, поэтому в хук передаётся ошибка первой строки
+	ctx, endQuery := ptr.startQuery(ctx, query, args)
+	// строка читается в Scan после возврата, контекст освобождается по таймауту
+	queryCtx, _ := ptr.queryContext(ctx)
+	row := ptr.conn.QueryRowContext(queryCtx, query, args...)
+	endQuery(row.Err())
+	return row
+}
+
+/*
+Count - counting rows of table matching condition, empty condition counts the whole table
+*/
+func (ptr *Postgres) Count(ctx context.Context, table, condition string, args ...interface{}) (int64, error) {
+	query := "SELECT COUNT(*) FROM " + quoteIdent(table)
+	if len(strings.TrimSpace(condition)) != 0 {
+		query += " WHERE " + condition
+	}
+
+	ctx, cancel := ptr.queryContext(ctx)
+	defer cancel()
+
+	var count int64
+	if err := ptr.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, queryError(ctx, err, query)
+	}
+	return count, nil
+}
+
+/*
+Exists - checking that table has at least one row matching condition, empty condition checks the whole table
+*/
+func (ptr *Postgres) Exists(ctx context.Context, table, condition string, args ...interface{}) (bool, error) {
+	query := "SELECT 1 FROM " + quoteIdent(table)
+	if len(strings.TrimSpace(condition)) != 0 {
+		query += " WHERE " + condition
+	}
+	query = "SELECT EXISTS(" + query + ")"
+
+	ctx, cancel := ptr.queryContext(ctx)
+	defer cancel()
+
+	var exists bool
+	if err := ptr.QueryRow(ctx, query, args...).Scan(&exists); err != nil {
+		return false, queryError(ctx, err, query)
+	}
+	return exists, nil
+}
+
+/*
+LoadMulti - selecting several result sets from DB, each result set is passed to the handler with the same index
+*/
+func (ptr *Postgres) LoadMulti(ctx context.Context, query string, args []interface{}, handlers []func(*sql.Rows) error) error {
+	if err := ptr.checkConnection(ctx); err != nil {
+		return err
+	}
+
+	ctx, endQuery := ptr.startQuery(ctx, query, args)
+	ctx, cancel := ptr.queryContext(ctx)
+	defer cancel()
+
+	rows, err := ptr.conn.QueryContext(ctx, query, args...)
+	endQuery(err)
+	if err != nil {
+		return queryError(ctx, err, query)
+	}
+	defer rows.Close()
+
+	for i := 0; ; i++ {
+		if i >= len(handlers) {
+			return fmt.Errorf("handler for result set %d not found, query: %s", i, query)
+		}
+
+		if err := handlers[i](rows); err != nil {
+			return err
+		}
+
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return errors.New(err.Error() + ", query: " + query)
+	}
+
+	return nil
+}
+
+/*
+LoadWhere - selecting fields from table by conditions built with BuildWhere
+*/
+func (ptr *Postgres) LoadWhere(ctx context.Context, table string, fields []string, conditions map[string]interface{}) (*sql.Rows, error) {
+	if err := ptr.checkConnection(ctx); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + quoteIdents(fields) + " FROM " + quoteIdent(table)
+	clause, args, _, err := buildWhere(conditions, 1, ptr.placeholder)
+	if err != nil {
+		return nil, err
+	}
+	if len(clause) != 0 {
+		query += " WHERE " + clause
+	}
+
+	ctx, endQuery := ptr.startQuery(ctx, query, args)
+	queryCtx, _ := ptr.queryContext(ctx)
+	rows, err := ptr.conn.QueryContext(queryCtx, query, args...)
+	endQuery(err)
+	if err != nil {
+		return rows, queryError(queryCtx, err, query)
+	}
+
+	return rows, nil
+}
+
+/*
+BuildWhere - building "col1 = $1 AND col2 = $2" condition from map, columns are sorted by name,
+placeholders are numbered from startIndex. Returns the clause, its arguments and the next free placeholder index,
+error if a column name is not a valid identifier
+*/
+func BuildWhere(conditions map[string]interface{}, startIndex int) (clause string, args []interface{}, nextIndex int, err error) {
+	return buildWhere(conditions, startIndex, PositionalDollar)
+}
+
+// условие с плейсхолдерами в формате format, используется методами Postgres с учётом SetPlaceholderFormat
+func buildWhere(conditions map[string]interface{}, startIndex int, format PlaceholderFormat) (clause string, args []interface{}, nextIndex int, err error) {
+	columns := make([]string, 0, len(conditions))
+	for column := range conditions {
+		if !identifierRegexp.MatchString(column) {
+			return "", nil, startIndex, errors.New("invalid column name in condition: " + strconv.Quote(column))
+		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	nextIndex = startIndex
+	parts := make([]string, 0, len(columns))
+	for _, column := range columns {
+		parts = append(parts, quoteIdent(column)+" = "+format(nextIndex, column))
+		args = append(args, conditions[column])
+		nextIndex++
+	}
+
+	return strings.Join(parts, " AND "), args, nextIndex, nil
+}
+
 /*
 Save — method inserts in DB row on duplicate key updates fields
 */
@@ -120,7 +619,25 @@ func (ptr *Postgres) Save(ctx context.Context, table string, fields []string, va
 	return result, err
 }
 
+/*
+SaveBulk — method inserts in DB many rows on duplicate key updates fields.
+Rows are split into chunks to fit the parameters limit of one query, all chunks are saved in single transaction,
+returned result contains total number of affected rows
+*/
 func (ptr *Postgres) SaveBulk(ctx context.Context, table string, fields []string, rows [][]interface{}, keys []string) (sql.Result, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("fields list is empty")
+	}
+	if len(rows) > 1 && ptr.namedPlaceholders() {
+		return nil, errNamedPlaceholdersRepeat
+	}
+
+	return ptr.execChunked(ctx, len(rows), maxQueryParams/len(fields), func(start, end int) (string, []interface{}) {
+		return ptr.generateSaveBulkQuery(table, fields, rows[start:end], keys)
+	})
+}
+
+func (ptr *Postgres) generateSaveBulkQuery(table string, fields []string, rows [][]interface{}, keys []string) (string, []interface{}) {
 	query := ptr.generateInsertBulkQuery(table, fields, len(rows))
 	query += ptr.generateOnConflictBulkQuery(fields, keys)
 
@@ -130,11 +647,8 @@ func (ptr *Postgres) SaveBulk(ctx context.Context, table string, fields []string
 			valueArgs = append(valueArgs, value)
 		}
 	}
-	result, err := ptr.execute(ctx, query, valueArgs)
-	if err != nil {
-		err = errors.New(err.Error() + ", query: " + query)
-	}
-	return result, err
+
+	return query, valueArgs
 }
 
 /*
@@ -157,6 +671,16 @@ func (ptr *Postgres) execute(ctx context.Context, query string, values []interfa
 		return
 	}
 
+	ptr.logQuery(query)
+
+	ctx, endQuery := ptr.startQuery(ctx, query, values)
+	defer func() {
+		endQuery(err)
+	}()
+
+	ctx, cancel := ptr.queryContext(ctx)
+	defer cancel()
+
 	stmt, err := ptr.conn.PrepareContext(ctx, query)
 	if err != nil {
 		return nil, errors.New(err.Error() + ", query: " + query)
@@ -166,10 +690,22 @@ func (ptr *Postgres) execute(ctx context.Context, query string, values []interfa
 	return stmt.ExecContext(ctx, values...)
 }
 
-func (ptr *Postgres) Update(ctx context.Context, table string, fields []string, values []interface{}, condition string) (sql.Result, error) {
+/*
+Update - updating fields in rows matching condition. SET uses placeholders $1..$len(fields),
+so condition placeholders are numbered relative to conditionArgs ("id = $1"), shifted automatically
+to continue after SET placeholders and converted to the format set by SetPlaceholderFormat
+*/
+func (ptr *Postgres) Update(ctx context.Context, table string, fields []string, values []interface{}, condition string, conditionArgs ...interface{}) (sql.Result, error) {
 	if len(fields) != len(values) {
 		return nil, errors.New("length of fields and length of values are different")
 	}
+	if len(conditionArgs) > 0 {
+		if ptr.namedPlaceholders() {
+			return nil, errors.New("condition args can't be numbered with named placeholders, use UpdateWhere")
+		}
+		condition = ptr.shiftPlaceholders(condition, len(fields))
+		values = append(values[:len(values):len(values)], conditionArgs...)
+	}
 	query := ptr.generateUpdateQuery(table, fields, condition)
 	result, err := ptr.execute(ctx, query, values)
 	if err != nil {
@@ -178,6 +714,165 @@ func (ptr *Postgres) Update(ctx context.Context, table string, fields []string,
 	return result, err
 }
 
+/*
+UpdateWhere - updating columns from set in rows matching where conditions,
+SET and WHERE placeholders are numbered sequentially so they never overlap
+*/
+func (ptr *Postgres) UpdateWhere(ctx context.Context, table string, set map[string]interface{}, where map[string]interface{}) (sql.Result, error) {
+	if len(set) == 0 {
+		return nil, errors.New("update set is empty")
+	}
+	if len(where) == 0 {
+		return nil, errors.New("update condition is empty")
+	}
+
+	fields := SortedMapKeys(set)
+	values := make([]interface{}, 0, len(set)+len(where))
+	for _, field := range fields {
+		values = append(values, set[field])
+	}
+
+	if ptr.namedPlaceholders() {
+		for column := range where {
+			if _, ok := set[column]; ok {
+				return nil, errNamedPlaceholdersRepeat
+			}
+		}
+	}
+
+	condition, args, _, err := buildWhere(where, len(fields)+1, ptr.placeholder)
+	if err != nil {
+		return nil, err
+	}
+	values = append(values, args...)
+
+	query := ptr.generateUpdateQuery(table, fields, condition)
+	result, err := ptr.execute(ctx, query, values)
+	if err != nil {
+		err = errors.New(err.Error() + ", query: " + query)
+	}
+	return result, err
+}
+
+/*
+Delete - deleting rows matching condition, empty condition is rejected to prevent clearing the whole table
+*/
+func (ptr *Postgres) Delete(ctx context.Context, table string, condition string, args ...interface{}) (sql.Result, error) {
+	if len(strings.TrimSpace(condition)) == 0 {
+		return nil, errors.New("delete condition is empty")
+	}
+	query := "DELETE FROM " + quoteIdent(table) + " WHERE " + condition
+	result, err := ptr.execute(ctx, query, args)
+	if err != nil {
+		err = errors.New(err.Error() + ", query: " + query)
+	}
+	return result, err
+}
+
+/*
+DeleteWhere - deleting rows matching conditions built with BuildWhere, empty conditions are rejected
+*/
+func (ptr *Postgres) DeleteWhere(ctx context.Context, table string, where map[string]interface{}) (sql.Result, error) {
+	condition, args, _, err := buildWhere(where, 1, ptr.placeholder)
+	if err != nil {
+		return nil, err
+	}
+	return ptr.Delete(ctx, table, condition, args...)
+}
+
+/*
+DeleteByKeys - deleting rows by list of keys, keys are split into chunks under the parameters limit,
+chunks are deleted in single transaction
+*/
+func (ptr *Postgres) DeleteByKeys(ctx context.Context, table string, keyColumn string, keys []interface{}) (sql.Result, error) {
+	if len(keys) == 0 {
+		return bulkResult{}, nil
+	}
+	if len(keys) > 1 && ptr.namedPlaceholders() {
+		return nil, errNamedPlaceholdersRepeat
+	}
+
+	return ptr.execChunked(ctx, len(keys), maxQueryParams, func(start, end int) (string, []interface{}) {
+		return ptr.generateDeleteByKeysQuery(table, keyColumn, end-start), keys[start:end]
+	})
+}
+
+/*
+execChunked - executing query for n items split into chunks of chunkSize, query and its args
+for items [start, end) are built by fn. Several chunks are executed in single transaction,
+returned result contains total number of affected rows
+*/
+func (ptr *Postgres) execChunked(ctx context.Context, n, chunkSize int, fn func(start, end int) (string, []interface{})) (sql.Result, error) {
+	// строка с числом полей больше лимита параметров всё равно передаётся одним запросом
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	if n <= chunkSize {
+		query, args := fn(0, n)
+		result, err := ptr.execute(ctx, query, args)
+		if err != nil {
+			err = errors.New(err.Error() + ", query: " + query)
+		}
+		return result, err
+	}
+
+	if err := ptr.checkConnection(ctx); err != nil {
+		return nil, err
+	}
+
+	tx, err := ptr.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	complete := false
+	defer func() {
+		if !complete {
+			tx.Rollback()
+		}
+	}()
+
+	var total bulkResult
+	for start := 0; start < n; start += chunkSize {
+		end := start + chunkSize
+		if end > n {
+			end = n
+		}
+
+		query, args := fn(start, end)
+		ptr.logQuery(query)
+		queryCtx, endQuery := ptr.startQuery(ctx, query, args)
+		result, err := tx.ExecContext(queryCtx, query, args...)
+		endQuery(err)
+		if err != nil {
+			return nil, errors.New(err.Error() + ", query: " + query)
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		total.rowsAffected += affected
+	}
+
+	complete = true
+
+	return total, tx.Commit()
+}
+
+// bulkResult - суммарный результат запросов, выполненных по частям
+type bulkResult struct {
+	rowsAffected int64
+}
+
+func (r bulkResult) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported by bulk result")
+}
+
+func (r bulkResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
 /*
 Exec - executing prepared SQL string
 */
@@ -186,13 +881,61 @@ func (ptr *Postgres) Exec(ctx context.Context, query string) (rows *sql.Rows, er
 		return
 	}
 
-	rows, err = ptr.conn.QueryContext(ctx, query)
+	ctx, endQuery := ptr.startQuery(ctx, query, nil)
+	defer func() {
+		endQuery(err)
+	}()
+
+	queryCtx, _ := ptr.queryContext(ctx)
+	rows, err = ptr.conn.QueryContext(queryCtx, query)
 	if err != nil {
-		err = errors.New(err.Error() + ", query: " + query)
+		err = queryError(queryCtx, err, query)
 	}
 	return rows, err
 }
 
+// при отмене контекста драйвер возвращает собственную ошибку,
+// вызывающему коду нужна причина отмены, чтобы её можно было проверить
+func queryError(ctx context.Context, err error, query string) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return errors.New(err.Error() + ", query: " + query)
+}
+
+// cancel освобождает контекст до истечения таймаута, когда результат запроса уже прочитан.
+// Строки, возвращаемые из Load и Exec, читаются после возврата, поэтому там контекст
+// освобождается собственным таймером context.WithTimeout по истечении таймаута
+func (ptr *Postgres) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ptr.config == nil || ptr.config.QueryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, ptr.config.QueryTimeout.Duration())
+}
+
+/*
+Ping - checking DB availability (e.g. for readiness probe), connects first if connection is not open yet,
+unlike other methods doesn't reconnect when pool has no open connections
+*/
+func (ptr *Postgres) Ping(ctx context.Context) error {
+	if ptr.conn == nil {
+		return ptr.Connect(ctx)
+	}
+	return ptr.conn.PingContext(ctx)
+}
+
+// статистика пула соединений, нулевая до подключения
+func (ptr *Postgres) Stats() sql.DBStats {
+	if ptr.conn == nil {
+		return sql.DBStats{}
+	}
+	return ptr.conn.Stats()
+}
+
 func (ptr *Postgres) checkConnection(ctx context.Context) error {
 	if ptr.conn == nil {
 		return ptr.Connect(ctx)
@@ -204,30 +947,47 @@ func (ptr *Postgres) checkConnection(ctx context.Context) error {
 	//return ptr.conn.PingContext(ctx)
 }
 
+// идентификатор в двойных кавычках, чтобы зарезервированные слова (order, user, group) можно было использовать
+// как имена колонок, имена вида schema.table экранируются по частям
+func quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.Replace(part, `"`, `""`, -1) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+func quoteIdents(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, quoteIdent(name))
+	}
+	return strings.Join(quoted, ",")
+}
+
 func (ptr *Postgres) generateInsertQuery(table string, fields []string) string {
-	query := "INSERT INTO " + table + " (" + strings.Join(fields, ",") + ") VALUES "
+	query := "INSERT INTO " + quoteIdent(table) + " (" + quoteIdents(fields) + ") VALUES "
 
 	valueStrings := make([]string, 0, len(fields))
-	for i := range fields {
-		key := strconv.Itoa((i + 1))
-		valueStrings = append(valueStrings, "$"+key)
+	for i, field := range fields {
+		valueStrings = append(valueStrings, ptr.placeholder(i+1, field))
 	}
 	query += "(" + strings.Join(valueStrings, ",") + ")"
 	return query
 }
 
 func (ptr *Postgres) generateInsertBulkQuery(table string, fields []string, rows int) string {
-	query := "INSERT INTO " + table + " (" + strings.Join(fields, ",") + ") VALUES "
+	query := "INSERT INTO " + quoteIdent(table) + " (" + quoteIdents(fields) + ") VALUES "
 
 	flen := len(fields)
 	valueStrings := make([]string, 0, rows)
 	for i := 0; i < rows; i++ {
 		var values string
-		for j := 1; j <= flen; j++ {
+		for j, field := range fields {
 			if len(values) > 0 {
 				values += ", "
 			}
-			values += fmt.Sprintf("$%d", i*flen+j)
+			values += ptr.placeholder(i*flen+j+1, field)
 		}
 		valueStrings = append(valueStrings, fmt.Sprintf("(%s)", values))
 	}
@@ -236,13 +996,96 @@ func (ptr *Postgres) generateInsertBulkQuery(table string, fields []string, rows
 	return query
 }
 
+/*
+shiftPlaceholders - increasing numbers of $N placeholders by offset and converting them to the format set by SetPlaceholderFormat.
+$N inside string literals ('...', E'...', $tag$...$tag$), quoted identifiers and identifiers like a$1 are left intact
+*/
+func (ptr *Postgres) shiftPlaceholders(condition string, offset int) string {
+	var result strings.Builder
+
+	for i := 0; i < len(condition); {
+		end := i + 1
+
+		switch c := condition[i]; {
+		case c == '\'' || c == '"':
+			escapes := c == '\'' && i > 0 && (condition[i-1] == 'E' || condition[i-1] == 'e') && !isIdentChar(condition, i-2)
+			end = quotedEnd(condition, i, escapes)
+		case c == '$' && !isIdentChar(condition, i-1):
+			if tag := dollarQuoteTag(condition[i:]); len(tag) > 0 {
+				end = len(condition)
+				if closing := strings.Index(condition[i+len(tag):], tag); closing >= 0 {
+					end = i + len(tag) + closing + len(tag)
+				}
+				break
+			}
+			for end < len(condition) && condition[end] >= '0' && condition[end] <= '9' {
+				end++
+			}
+			if end > i+1 {
+				index, _ := strconv.Atoi(condition[i+1 : end])
+				result.WriteString(ptr.placeholder(index+offset, ""))
+				i = end
+				continue
+			}
+		case isIdentChar(condition, i):
+			for end < len(condition) && (isIdentChar(condition, end) || condition[end] == '$') {
+				end++
+			}
+		}
+
+		result.WriteString(condition[i:end])
+		i = end
+	}
+
+	return result.String()
+}
+
+// позиция после закрывающей кавычки литерала, начинающегося в start, удвоенная кавычка не закрывает литерал
+func quotedEnd(s string, start int, backslashEscapes bool) int {
+	quote := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch {
+		case backslashEscapes && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// открывающий тег строки в долларовых кавычках ($$ или $tag$), пустая строка, если s начинается не с него
+func dollarQuoteTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '$' {
+			return s[:i+1]
+		}
+		// $1 - плейсхолдер, а не тег
+		if !isIdentChar(s, i) || i == 1 && s[i] >= '0' && s[i] <= '9' {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentChar(s string, i int) bool {
+	if i < 0 || i >= len(s) {
+		return false
+	}
+	c := s[i]
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
 func (ptr *Postgres) generateUpdateQuery(table string, fields []string, condition string) string {
-	query := "UPDATE " + table + " SET "
+	query := "UPDATE " + quoteIdent(table) + " SET "
 	var placeholder []string
 
 	for i, name := range fields {
-		key := strconv.Itoa((i + 1))
-		placeholder = append(placeholder, name+"=$"+key)
+		placeholder = append(placeholder, quoteIdent(name)+"="+ptr.placeholder(i+1, name))
 	}
 	query += strings.Join(placeholder, ",")
 
@@ -253,22 +1096,29 @@ func (ptr *Postgres) generateUpdateQuery(table string, fields []string, conditio
 	return query
 }
 
+func (ptr *Postgres) generateDeleteByKeysQuery(table string, keyColumn string, count int) string {
+	placeholder := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		placeholder = append(placeholder, ptr.placeholder(i+1, keyColumn))
+	}
+
+	return "DELETE FROM " + quoteIdent(table) + " WHERE " + quoteIdent(keyColumn) + " IN (" + strings.Join(placeholder, ",") + ")"
+}
+
 func (ptr *Postgres) generateOnConflictQuery(fields []string, keys []string) string {
 	if len(keys) == 0 {
 		return " ON CONFLICT DO NOTHING "
 	}
 
-	query := " ON CONFLICT (" + strings.Join(keys, ",") + ") DO UPDATE SET "
+	// значения берутся из excluded, а не повтором плейсхолдеров, чтобы количество аргументов не зависело от формата
+	query := " ON CONFLICT (" + quoteIdents(keys) + ") DO UPDATE SET "
 
-	var placeholder []string
-	for i, field := range fields {
-		key := strconv.Itoa(i + 1)
-		value := field + " = $" + key + " "
-		placeholder = append(placeholder, value)
+	var values []string
+	for _, field := range fields {
+		values = append(values, quoteIdent(field)+" = excluded."+quoteIdent(field))
 	}
 
-	query += strings.Join(placeholder, ",")
-	query = query[:len(query)-1]
+	query += strings.Join(values, ", ")
 	return query
 }
 
@@ -277,14 +1127,14 @@ func (ptr *Postgres) generateOnConflictBulkQuery(fields []string, keys []string)
 		return " ON CONFLICT DO NOTHING "
 	}
 
-	query := " ON CONFLICT (" + strings.Join(keys, ",") + ") DO UPDATE SET "
+	query := " ON CONFLICT (" + quoteIdents(keys) + ") DO UPDATE SET "
 
 	var values string
 	for _, field := range fields {
 		if len(values) > 0 {
 			values += ", "
 		}
-		values += field + " = excluded." + field
+		values += quoteIdent(field) + " = excluded." + quoteIdent(field)
 	}
 
 	query += values
@@ -295,13 +1145,16 @@ func (ptr *Postgres) InsertBatch(ctx context.Context, table string, fields []str
 	if len(rows) == 0 {
 		return nil
 	}
+	if len(rows) > 1 && ptr.namedPlaceholders() {
+		return errNamedPlaceholdersRepeat
+	}
 
 	if err := ptr.checkConnection(ctx); err != nil {
 		return err
 	}
 
 	var values = []interface{}{}
-	SQL := "insert into " + table + " (" + strings.Join(fields, ",") + ") values "
+	SQL := "insert into " + quoteIdent(table) + " (" + quoteIdents(fields) + ") values "
 
 	var placeholder []string
 
@@ -311,7 +1164,11 @@ func (ptr *Postgres) InsertBatch(ctx context.Context, table string, fields []str
 		var pl []string
 		for i := 0; i < len(r); i++ {
 			counter++
-			pl = append(pl, "$"+strconv.Itoa(counter))
+			var field string
+			if i < len(fields) {
+				field = fields[i]
+			}
+			pl = append(pl, ptr.placeholder(counter, field))
 			values = append(values, r[i])
 		}
 		placeholder = append(placeholder, "("+strings.Join(pl, ",")+")")
@@ -367,6 +1224,84 @@ func (ptr *Postgres) ExecTransaction(ctx context.Context, queries []string) erro
 	return tx.Commit()
 }
 
+/*
+WithTransaction - executing fn inside transaction, commits if fn returns nil,
+rolls back if fn returns error or panics (panic is re-raised after rollback)
+*/
+func (ptr *Postgres) WithTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if err := ptr.checkConnection(ctx); err != nil {
+		return err
+	}
+
+	tx, err := ptr.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Tx - транзакция с поддержкой точек сохранения
+type Tx struct {
+	*sql.Tx
+}
+
+/*
+Begin - starting new transaction, transaction is rolled back if ctx is cancelled before commit
+*/
+func (ptr *Postgres) Begin(ctx context.Context) (*Tx, error) {
+	if err := ptr.checkConnection(ctx); err != nil {
+		return nil, err
+	}
+
+	tx, err := ptr.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{Tx: tx}, nil
+}
+
+/*
+Savepoint - creating savepoint inside transaction
+*/
+func (tx *Tx) Savepoint(name string) error {
+	return tx.execSavepointQuery("SAVEPOINT " + pq.QuoteIdentifier(name))
+}
+
+/*
+RollbackTo - rolling back all work done after savepoint, transaction stays active
+*/
+func (tx *Tx) RollbackTo(name string) error {
+	return tx.execSavepointQuery("ROLLBACK TO SAVEPOINT " + pq.QuoteIdentifier(name))
+}
+
+/*
+ReleaseSavepoint - destroying savepoint, work done after it is kept
+*/
+func (tx *Tx) ReleaseSavepoint(name string) error {
+	return tx.execSavepointQuery("RELEASE SAVEPOINT " + pq.QuoteIdentifier(name))
+}
+
+func (tx *Tx) execSavepointQuery(query string) error {
+	if _, err := tx.Exec(query); err != nil {
+		return errors.New(err.Error() + ", query: " + query)
+	}
+	return nil
+}
+
 func (ptr *Postgres) ExecInsertTransaction(ctx context.Context, queryCtx []*QueryContext) error {
 	if err := ptr.checkConnection(ctx); err != nil {
 		return err
@@ -409,6 +1344,22 @@ func (ptr *Postgres) ExecInsertTransaction(ctx context.Context, queryCtx []*Quer
 }
 
 func (ptr *Postgres) Listen(ctx context.Context, channel string) error {
+	return ptr.ListenChannels(ctx, []string{channel})
+}
+
+/*
+ListenChannels - subscribing to several notification channels on one listener connection and handling
+notifications until ctx is cancelled. After reconnect pq re-subscribes to the channels itself,
+notifications sent while disconnected are lost, the handler set by OnReconnect is called to resync state
+*/
+func (ptr *Postgres) ListenChannels(ctx context.Context, channels []string) error {
+	if len(channels) == 0 {
+		return errors.New("listen failed, no channels")
+	}
+	// при нулевом интервале цикл обработки уведомлений непрерывно пингует listener
+	if ptr.listenIdleTimeout <= 0 {
+		return errors.New("listen failed, listen idle timeout must be greater than zero")
+	}
 	if err := ptr.checkConnection(ctx); err != nil {
 		return err
 	}
@@ -419,16 +1370,31 @@ func (ptr *Postgres) Listen(ctx context.Context, channel string) error {
 				ptr.errorHandler(err)
 			}
 		}
+
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			log.Println("W> listener disconnected, channels: " + strings.Join(channels, ", "))
+		case pq.ListenerEventReconnected:
+			log.Println("I> listener reconnected, channels: " + strings.Join(channels, ", "))
+		}
 	}
 
-	ptr.listener = pq.NewListener(ptr.connectionInfo, 10*time.Second, time.Minute, reportProblem)
+	listener := pq.NewListener(ptr.connectionInfo, listenMinReconnectInterval, listenMaxReconnectInterval, reportProblem)
+	ptr.refMu.Lock()
+	ptr.listener = listener
+	ptr.refMu.Unlock()
 
-	if err := ptr.listener.Listen(channel); err != nil {
-		return err
+	for _, channel := range channels {
+		if err := listener.Listen(channel); err != nil {
+			return err
+		}
 	}
 
 	for {
-		ptr.HandleListen()
+		// после Close канал уведомлений закрыт, продолжение цикла нагружало бы CPU впустую
+		if !ptr.HandleListen() {
+			return nil
+		}
 
 		if IsContextCancelled(ctx) {
 			break
@@ -438,38 +1404,123 @@ func (ptr *Postgres) Listen(ctx context.Context, channel string) error {
 	return nil
 }
 
-func (ptr *Postgres) HandleListen() {
+// false, если канал уведомлений закрыт (listener закрыт через Close)
+func (ptr *Postgres) HandleListen() bool {
+	// listener заменяется в ListenChannels и закрывается в Close под refMu
+	ptr.refMu.Lock()
 	l := ptr.listener
+	ptr.refMu.Unlock()
 	for {
 		select {
-		case n := <-l.Notify:
-			if n != nil {
-				ptr.handler(n.Extra)
+		case n, ok := <-l.Notify:
+			if !ok {
+				return false
 			}
-			return
+			// nil приходит после переподключения: уведомления за время разрыва могли быть потеряны
+			if n == nil {
+				if ptr.reconnectHandler != nil {
+					ptr.handlerMu.Lock()
+					ptr.reconnectHandler()
+					ptr.handlerMu.Unlock()
+				}
+				return true
+			}
+			ptr.handlerMu.Lock()
+			ptr.handler(n.Channel, n.Extra)
+			ptr.handlerMu.Unlock()
+			return true
 
 		case <-time.After(ptr.listenIdleTimeout):
 			go func() {
 				l.Ping()
 			}()
-			return
+			return true
 		}
 	}
 }
 
-func (ptr *Postgres) OnData(handler func(string)) {
+// ожидание завершения обработки текущего уведомления, но не дольше timeout
+func (ptr *Postgres) waitListenHandler(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		ptr.handlerMu.Lock()
+		ptr.handlerMu.Unlock()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Println("W> listen handler is still running after " + timeout.String() + ", closing listener")
+	}
+}
+
+// handler получает имя канала уведомления, чтобы при подписке через ListenChannels разделять обработку по каналам
+func (ptr *Postgres) OnData(handler func(channel, payload string)) {
 	ptr.handler = handler
 }
 
+// handler вызывается после переподключения listener, чтобы перечитать состояние, изменения которого могли быть пропущены
+func (ptr *Postgres) OnReconnect(handler func()) {
+	ptr.reconnectHandler = handler
+}
+
 func (ptr *Postgres) OnError(handler func(error)) {
 	ptr.errorHandler = handler
 }
 
+/*
+OnDataTyped - setting notifications handler receiving JSON payload decoded into T,
+decoding errors are passed to the error handler set by OnError
+*/
+func OnDataTyped[T any](pg *Postgres, handler func(channel string, value T)) {
+	pg.OnData(func(channel, payload string) {
+		var value T
+		if err := json.Unmarshal([]byte(payload), &value); err != nil {
+			err = errors.New("can't decode notification payload, channel: " + channel + ", " + err.Error())
+			if pg.errorHandler != nil {
+				pg.errorHandler(err)
+			} else {
+				log.Println("E> " + err.Error())
+			}
+			return
+		}
+		handler(channel, value)
+	})
+}
+
 func (m *Postgres) GetDBInfo() string {
 	return m.config.Host + "/" + m.config.Database
 }
 
+/*
+Acquire - registering one more user of shared instance, each user must call Close,
+connection is closed only by the last Close call
+*/
+func (ptr *Postgres) Acquire() *Postgres {
+	ptr.refMu.Lock()
+	ptr.refs++
+	ptr.refMu.Unlock()
+	return ptr
+}
+
 func (ptr *Postgres) Close() error {
+	ptr.refMu.Lock()
+	defer ptr.refMu.Unlock()
+
+	if ptr.refs > 0 {
+		ptr.refs--
+		return nil
+	}
+
+	if ptr.listener != nil {
+		ptr.waitListenHandler(listenerCloseTimeout)
+		ptr.listener.Close()
+	}
+
 	if ptr.conn != nil {
 		err := ptr.conn.Close()
 		return err
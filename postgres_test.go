@@ -0,0 +1,947 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestPlaceholderFormats(t *testing.T) {
+	cases := []struct {
+		name       string
+		format     PlaceholderFormat
+		insert     string
+		bulk       string
+		update     string
+		where      string
+		shifted    string
+		deleteKeys string
+	}{
+		{
+			name:       "PositionalDollar",
+			format:     PositionalDollar,
+			insert:     `INSERT INTO "t" ("a","b") VALUES ($1,$2)`,
+			bulk:       `INSERT INTO "t" ("a","b") VALUES ($1, $2),($3, $4)`,
+			update:     `UPDATE "t" SET "a"=$1,"b"=$2 WHERE "id" = $3`,
+			where:      `"a" = $2 AND "b" = $3`,
+			shifted:    `"id" = $3 AND "v" > $4`,
+			deleteKeys: `DELETE FROM "t" WHERE "id" IN ($1,$2)`,
+		},
+		{
+			name:       "QuestionMark",
+			format:     QuestionMark,
+			insert:     `INSERT INTO "t" ("a","b") VALUES (?,?)`,
+			bulk:       `INSERT INTO "t" ("a","b") VALUES (?, ?),(?, ?)`,
+			update:     `UPDATE "t" SET "a"=?,"b"=? WHERE "id" = ?`,
+			where:      `"a" = ? AND "b" = ?`,
+			shifted:    `"id" = ? AND "v" > ?`,
+			deleteKeys: `DELETE FROM "t" WHERE "id" IN (?,?)`,
+		},
+		{
+			name:   "NamedColon",
+			format: NamedColon,
+			insert: `INSERT INTO "t" ("a","b") VALUES (:a,:b)`,
+			update: `UPDATE "t" SET "a"=:a,"b"=:b WHERE "id" = :id`,
+			where:  `"a" = :a AND "b" = :b`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pg := NewPostgres()
+			pg.SetPlaceholderFormat(c.format)
+
+			if query := pg.generateInsertQuery("t", []string{"a", "b"}); query != c.insert {
+				t.Errorf("insert query %q, expected %q", query, c.insert)
+			}
+
+			condition, _, _, _ := buildWhere(map[string]interface{}{"id": 1}, 3, pg.placeholder)
+			if query := pg.generateUpdateQuery("t", []string{"a", "b"}, condition); query != c.update {
+				t.Errorf("update query %q, expected %q", query, c.update)
+			}
+
+			clause, args, next, _ := buildWhere(map[string]interface{}{"b": 2, "a": 1}, 2, pg.placeholder)
+			if clause != c.where || !reflect.DeepEqual(args, []interface{}{1, 2}) || next != 4 {
+				t.Errorf("where clause %q, args %v, next %d, expected %q", clause, args, next, c.where)
+			}
+
+			if pg.namedPlaceholders() {
+				return
+			}
+
+			if query := pg.generateInsertBulkQuery("t", []string{"a", "b"}, 2); query != c.bulk {
+				t.Errorf("bulk insert query %q, expected %q", query, c.bulk)
+			}
+			if query := pg.shiftPlaceholders(`"id" = $1 AND "v" > $2`, 2); query != c.shifted {
+				t.Errorf("shifted condition %q, expected %q", query, c.shifted)
+			}
+			if query := pg.generateDeleteByKeysQuery("t", "id", 2); query != c.deleteKeys {
+				t.Errorf("delete by keys query %q, expected %q", query, c.deleteKeys)
+			}
+		})
+	}
+}
+
+func TestSaveQueryArgsMatchPlaceholders(t *testing.T) {
+	pg := NewPostgres()
+	pg.SetPlaceholderFormat(QuestionMark)
+
+	query := pg.generateInsertQuery("t", []string{"a", "b"}) + pg.generateOnConflictQuery([]string{"a", "b"}, []string{"a"})
+	if count := strings.Count(query, "?"); count != 2 {
+		t.Errorf("query %q has %d placeholders for 2 args", query, count)
+	}
+}
+
+func TestNamedPlaceholdersRejectRepeatedFields(t *testing.T) {
+	pg := NewPostgres()
+	pg.SetPlaceholderFormat(NamedColon)
+	ctx := context.Background()
+
+	if _, err := pg.SaveBulk(ctx, "t", []string{"a"}, [][]interface{}{{1}, {2}}, nil); err != errNamedPlaceholdersRepeat {
+		t.Errorf("SaveBulk error %v", err)
+	}
+	if _, err := pg.DeleteByKeys(ctx, "t", "id", []interface{}{1, 2}); err != errNamedPlaceholdersRepeat {
+		t.Errorf("DeleteByKeys error %v", err)
+	}
+	if err := pg.InsertBatch(ctx, "t", []string{"a"}, []interface{}{[]interface{}{1}, []interface{}{2}}, nil); err != errNamedPlaceholdersRepeat {
+		t.Errorf("InsertBatch error %v", err)
+	}
+	if _, err := pg.UpdateWhere(ctx, "t", map[string]interface{}{"a": 1}, map[string]interface{}{"a": 2}); err != errNamedPlaceholdersRepeat {
+		t.Errorf("UpdateWhere error %v", err)
+	}
+	if _, err := pg.Update(ctx, "t", []string{"a"}, []interface{}{1}, "id = $1", 2); err == nil {
+		t.Error("Update with condition args succeeded")
+	}
+}
+
+func TestBuildWhere(t *testing.T) {
+	cases := []struct {
+		conditions map[string]interface{}
+		clause     string
+		args       []interface{}
+		next       int
+	}{
+		{nil, "", nil, 1},
+		{map[string]interface{}{"id": 5}, `"id" = $1`, []interface{}{5}, 2},
+		{map[string]interface{}{"user": "a", "order": 2, "s.id": 3}, `"order" = $1 AND "s"."id" = $2 AND "user" = $3`, []interface{}{2, 3, "a"}, 4},
+	}
+
+	for _, c := range cases {
+		clause, args, next, err := BuildWhere(c.conditions, 1)
+		if err != nil || clause != c.clause || !reflect.DeepEqual(args, c.args) || next != c.next {
+			t.Errorf("BuildWhere(%v) = %q, %v, %d, %v, expected %q, %v, %d", c.conditions, clause, args, next, err, c.clause, c.args, c.next)
+		}
+	}
+
+	if _, _, _, err := BuildWhere(map[string]interface{}{"id; DROP TABLE t": 1}, 1); err == nil {
+		t.Error("invalid column name accepted")
+	}
+}
+
+func TestWhereMethodsRejectInvalidColumns(t *testing.T) {
+	pg := NewPostgres()
+	ctx := context.Background()
+	where := map[string]interface{}{"bad name": 1}
+
+	if _, err := pg.UpdateWhere(ctx, "t", map[string]interface{}{"a": 1}, where); err == nil {
+		t.Error("UpdateWhere accepted invalid column")
+	}
+	if _, err := pg.DeleteWhere(ctx, "t", where); err == nil {
+		t.Error("DeleteWhere accepted invalid column")
+	}
+	if _, err := pg.DeleteWhere(ctx, "t", nil); err == nil {
+		t.Error("DeleteWhere accepted empty conditions")
+	}
+}
+
+func TestConnectionStringSocket(t *testing.T) {
+	config := DBConfig{User: "u", Password: "p", Host: "/var/run/postgresql", Database: "db"}
+	if connection := config.connectionString(); connection != "postgres://u:p@/db?host=/var/run/postgresql&sslmode=disable" {
+		t.Errorf("socket connection string %q", connection)
+	}
+
+	config.Port = 5433
+	if connection := config.connectionString(); connection != "postgres://u:p@/db?host=/var/run/postgresql&sslmode=disable&port=5433" {
+		t.Errorf("socket connection string with port %q", connection)
+	}
+}
+
+func TestConnectionStringSearchPath(t *testing.T) {
+	config := DBConfig{User: "u", Password: "p", Host: "localhost", Port: 5432, Database: "db", SearchPath: "test_1"}
+	if connection := config.connectionString(); connection != "postgres://u:p@localhost:5432/db?sslmode=disable&options=-c+search_path%3Dtest_1" {
+		t.Errorf("connection string with search path %q", connection)
+	}
+
+	pg := NewPostgres()
+	if err := pg.LoadConfig(&config); err != nil {
+		t.Fatal(err)
+	}
+	if err := pg.SetSearchPath(context.Background(), "test_2"); err != nil {
+		t.Fatal(err)
+	}
+	if pg.SearchPath() != "test_2" || config.SearchPath != "test_1" {
+		t.Errorf("search path %q, loaded config search path %q", pg.SearchPath(), config.SearchPath)
+	}
+}
+
+func TestListenIdleTimeout(t *testing.T) {
+	pg := NewPostgres()
+	if pg.listenIdleTimeout != defaultListenIdleTimeout {
+		t.Fatalf("default listen idle timeout = %v", pg.listenIdleTimeout)
+	}
+
+	pg.SetListenIdleTimeout(0)
+	if err := pg.ListenChannels(context.Background(), []string{"events"}); err == nil {
+		t.Fatal("ListenChannels accepted zero idle timeout")
+	}
+}
+
+func TestHandleListenDoesntBusyLoop(t *testing.T) {
+	pg := NewPostgres()
+	pg.SetListenIdleTimeout(50 * time.Millisecond)
+	pg.listener = &pq.Listener{Notify: make(chan *pq.Notification)}
+
+	// без уведомлений каждый вызов ждёт интервал простоя перед пингом
+	start := time.Now()
+	iterations := 0
+	for time.Since(start) < 200*time.Millisecond {
+		if !pg.HandleListen() {
+			t.Fatal("HandleListen stopped with open notification channel")
+		}
+		iterations++
+	}
+
+	if iterations > 5 {
+		t.Fatalf("%d idle iterations in 200ms, expected about 4", iterations)
+	}
+
+	close(pg.listener.Notify)
+	if pg.HandleListen() {
+		t.Fatal("HandleListen continued after notification channel was closed")
+	}
+}
+
+func TestCloseWaitsForListenHandler(t *testing.T) {
+	pg := NewPostgres()
+	pg.listener = &pq.Listener{Notify: make(chan *pq.Notification)}
+
+	started := make(chan struct{})
+	var finished int32
+	pg.OnData(func(channel, payload string) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	})
+
+	go pg.HandleListen()
+	pg.listener.Notify <- &pq.Notification{Channel: "events", Extra: "payload"}
+	<-started
+
+	if err := pg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatal("Close returned while notification handler was running")
+	}
+}
+
+// fakeDB - драйвер database/sql в памяти для тестов без сервера PostgreSQL,
+// записывает выполненные запросы, результат запроса задаётся функцией handle
+type fakeDB struct {
+	mu      sync.Mutex
+	queries []string
+	args    [][]interface{}
+	// nil rows - результат без строк, nil handle - пустой результат любого запроса
+	handle func(ctx context.Context, query string, args []interface{}) (*fakeRows, error)
+}
+
+type fakeRows struct {
+	columns [][]string
+	sets    [][][]driver.Value
+	set     int
+	row     int
+}
+
+// один набор результатов с колонками columns
+func newFakeRows(columns []string, rows ...[]driver.Value) *fakeRows {
+	return &fakeRows{columns: [][]string{columns}, sets: [][][]driver.Value{rows}}
+}
+
+func (r *fakeRows) Columns() []string { return r.columns[r.set] }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.row >= len(r.sets[r.set]) {
+		return io.EOF
+	}
+	copy(dest, r.sets[r.set][r.row])
+	r.row++
+	return nil
+}
+
+func (r *fakeRows) HasNextResultSet() bool { return r.set+1 < len(r.sets) }
+
+func (r *fakeRows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+	r.set++
+	r.row = 0
+	return nil
+}
+
+// RowsAffected запроса без строк результата равно количеству аргументов
+type fakeResult int64
+
+func (r fakeResult) LastInsertId() (int64, error) { return 0, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func (db *fakeDB) run(ctx context.Context, query string, named []driver.NamedValue) (*fakeRows, error) {
+	args := make([]interface{}, 0, len(named))
+	for _, arg := range named {
+		args = append(args, arg.Value)
+	}
+
+	db.mu.Lock()
+	db.queries = append(db.queries, query)
+	db.args = append(db.args, args)
+	handle := db.handle
+	db.mu.Unlock()
+
+	if handle == nil {
+		return nil, nil
+	}
+	return handle(ctx, query, args)
+}
+
+func (db *fakeDB) executed() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string(nil), db.queries...)
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, errors.New("use sql.OpenDB") }
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	_, err := c.db.run(ctx, "BEGIN", nil)
+	return &fakeTx{conn: c}, err
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, err := c.db.run(ctx, query, args); err != nil {
+		return nil, err
+	}
+	return fakeResult(len(args)), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.db.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = newFakeRows(nil)
+	}
+	return rows, nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("ExecContext expected")
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("QueryContext expected")
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+type fakeTx struct {
+	conn *fakeConn
+}
+
+func (tx *fakeTx) Commit() error {
+	_, err := tx.conn.db.run(context.Background(), "COMMIT", nil)
+	return err
+}
+
+func (tx *fakeTx) Rollback() error {
+	_, err := tx.conn.db.run(context.Background(), "ROLLBACK", nil)
+	return err
+}
+
+func newFakePostgres(t *testing.T, config DBConfig) (*Postgres, *fakeDB) {
+	db := &fakeDB{}
+	pg := NewPostgres()
+	pg.config = &config
+	pg.conn = sql.OpenDB(db)
+	t.Cleanup(func() { pg.Close() })
+	return pg, db
+}
+
+// запрос pg_sleep блокируется до отмены контекста, как запрос к недоступному серверу
+func blockUntilCancelled(ctx context.Context, query string, _ []interface{}) (*fakeRows, error) {
+	if !strings.Contains(query, "pg_sleep") {
+		return nil, nil
+	}
+	<-ctx.Done()
+	return nil, driver.ErrBadConn
+}
+
+func TestQueryTimeout(t *testing.T) {
+	pg, db := newFakePostgres(t, DBConfig{QueryTimeout: Duration(50 * time.Millisecond)})
+	db.handle = blockUntilCancelled
+
+	ctx := context.Background()
+	calls := map[string]func() error{
+		"Exec": func() error {
+			_, err := pg.Exec(ctx, "SELECT pg_sleep(10)")
+			return err
+		},
+		"LoadArgs": func() error {
+			_, err := pg.LoadArgs(ctx, "SELECT pg_sleep($1)", 10)
+			return err
+		},
+		"QueryRow": func() error {
+			var v int
+			return pg.QueryRow(ctx, "SELECT pg_sleep(10)").Scan(&v)
+		},
+		"Count": func() error {
+			_, err := pg.Count(ctx, "items", "pg_sleep(10) IS NULL")
+			return err
+		},
+		"Exists": func() error {
+			_, err := pg.Exists(ctx, "items", "pg_sleep(10) IS NULL")
+			return err
+		},
+		"LoadMulti": func() error {
+			return pg.LoadMulti(ctx, "SELECT pg_sleep(10)", nil, nil)
+		},
+		"Create": func() error {
+			_, err := pg.Create(ctx, "pg_sleep", []string{"value"}, []interface{}{10})
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		start := time.Now()
+		err := call()
+		if err == nil {
+			t.Errorf("%s: no error after query timeout", name)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: returned after %v", name, elapsed)
+		}
+	}
+
+	// дедлайн переданного контекста не заменяется таймаутом из конфига
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := pg.Exec(ctx, "SELECT pg_sleep(10)"); err != context.DeadlineExceeded {
+		t.Errorf("Exec with deadline: error %v, expected context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Exec with deadline returned after %v, before context deadline", elapsed)
+	}
+}
+
+func TestShiftPlaceholdersSkipsLiterals(t *testing.T) {
+	pg := NewPostgres()
+	cases := []struct {
+		condition, expected string
+	}{
+		{`id = $1 AND name = '$1'`, `id = $4 AND name = '$1'`},
+		{`name = 'it''s $2' OR id = $2`, `name = 'it''s $2' OR id = $5`},
+		{`name = E'\'$1' AND id = $1`, `name = E'\'$1' AND id = $4`},
+		{`"col$1" = $1`, `"col$1" = $4`},
+		{`col$1 = $1`, `col$1 = $4`},
+		{`body = $$ $1 $$ AND id = $1`, `body = $$ $1 $$ AND id = $4`},
+		{`body = $tag$ '$1' $tag$ AND id = $2`, `body = $tag$ '$1' $tag$ AND id = $5`},
+		{`id = $10`, `id = $13`},
+		{`name = 'unterminated $1`, `name = 'unterminated $1`},
+	}
+
+	for _, c := range cases {
+		if shifted := pg.shiftPlaceholders(c.condition, 3); shifted != c.expected {
+			t.Errorf("shiftPlaceholders(%q) = %q, expected %q", c.condition, shifted, c.expected)
+		}
+	}
+}
+
+func TestDBConfigValidate(t *testing.T) {
+	valid := DBConfig{User: "u", Password: "p", Host: "localhost", Port: 5432, Database: "db"}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid config: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		modify func(*DBConfig)
+		error  string
+	}{
+		{"host", func(c *DBConfig) { c.Host = "" }, "host not found"},
+		{"port", func(c *DBConfig) { c.Port = 0 }, "port not found"},
+		{"user", func(c *DBConfig) { c.User = "" }, "login or password not found"},
+		{"password", func(c *DBConfig) { c.Password = "" }, "login or password not found"},
+		{"database", func(c *DBConfig) { c.Database = "" }, "database name not found"},
+		{"sslmode", func(c *DBConfig) { c.SSLmode = "on" }, "invalid sslmode"},
+	}
+
+	for _, c := range cases {
+		config := valid
+		c.modify(&config)
+		if err := config.Validate(); err == nil || !strings.Contains(err.Error(), c.error) {
+			t.Errorf("missing %s: error %v, expected %q", c.name, err, c.error)
+		}
+
+		// LoadConfig не сохраняет невалидный конфиг
+		pg := NewPostgres()
+		if err := pg.LoadConfig(&config); err == nil || pg.config != nil {
+			t.Errorf("missing %s: LoadConfig error %v", c.name, err)
+		}
+	}
+
+	// для Unix-сокета порт не обязателен
+	socket := valid
+	socket.Host, socket.Port = "/var/run/postgresql", 0
+	if err := socket.Validate(); err != nil {
+		t.Errorf("socket config: %v", err)
+	}
+}
+
+func TestConnectionStringMultiHost(t *testing.T) {
+	config := DBConfig{User: "u", Password: "p", Host: "primary, standby", Port: 5432, Database: "db"}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if connection := config.connectionString(); connection != "postgres://u:p@primary:5432,standby:5432/db?sslmode=disable&target_session_attrs=read-write" {
+		t.Errorf("shared port connection string %q", connection)
+	}
+
+	config.Ports = []int{5432, 5433}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if connection := config.connectionString(); connection != "postgres://u:p@primary:5432,standby:5433/db?sslmode=disable&target_session_attrs=read-write" {
+		t.Errorf("per host ports connection string %q", connection)
+	}
+
+	config.Ports = []int{5432}
+	if err := config.Validate(); err == nil {
+		t.Error("ports count not matching hosts count accepted")
+	}
+
+	config.Ports, config.Port = nil, 0
+	if err := config.Validate(); err == nil {
+		t.Error("multi-host config without port accepted")
+	}
+}
+
+func TestLoadMulti(t *testing.T) {
+	pg, db := newFakePostgres(t, DBConfig{})
+	db.handle = func(context.Context, string, []interface{}) (*fakeRows, error) {
+		return &fakeRows{
+			columns: [][]string{{"id"}, {"name"}},
+			sets: [][][]driver.Value{
+				{{int64(1)}, {int64(2)}},
+				{{"a"}},
+			},
+		}, nil
+	}
+
+	var ids []int64
+	var names []string
+	err := pg.LoadMulti(context.Background(), "SELECT id FROM a; SELECT name FROM b", nil, []func(*sql.Rows) error{
+		func(rows *sql.Rows) error {
+			for rows.Next() {
+				var id int64
+				if err := rows.Scan(&id); err != nil {
+					return err
+				}
+				ids = append(ids, id)
+			}
+			return nil
+		},
+		func(rows *sql.Rows) error {
+			for rows.Next() {
+				var name string
+				if err := rows.Scan(&name); err != nil {
+					return err
+				}
+				names = append(names, name)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2}) || !reflect.DeepEqual(names, []string{"a"}) {
+		t.Fatalf("ids %v, names %v", ids, names)
+	}
+
+	// наборов результатов больше, чем обработчиков
+	err = pg.LoadMulti(context.Background(), "SELECT id FROM a; SELECT name FROM b", nil, []func(*sql.Rows) error{
+		func(*sql.Rows) error { return nil },
+	})
+	if err == nil || !strings.Contains(err.Error(), "handler for result set 1 not found") {
+		t.Fatalf("LoadMulti with missing handler: %v", err)
+	}
+}
+
+func TestDeleteByKeysChunked(t *testing.T) {
+	pg, db := newFakePostgres(t, DBConfig{})
+
+	keys := make([]interface{}, 2*maxQueryParams+10)
+	for i := range keys {
+		keys[i] = i
+	}
+
+	result, err := pg.DeleteByKeys(context.Background(), "items", "id", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// фейковый драйвер возвращает RowsAffected по количеству аргументов запроса
+	if affected, _ := result.RowsAffected(); affected != int64(len(keys)) {
+		t.Fatalf("RowsAffected() = %d, expected %d", affected, len(keys))
+	}
+
+	queries := db.executed()
+	if len(queries) != 5 || queries[0] != "BEGIN" || queries[4] != "COMMIT" {
+		t.Fatalf("expected 3 chunks in transaction, executed %d queries: %v ... %v", len(queries), queries[0], queries[len(queries)-1])
+	}
+	for _, query := range queries[1:4] {
+		if !strings.HasPrefix(query, `DELETE FROM "items" WHERE "id" IN (`) {
+			t.Fatalf("unexpected chunk query %.60s", query)
+		}
+	}
+}
+
+func TestTxSavepoint(t *testing.T) {
+	pg, db := newFakePostgres(t, DBConfig{})
+	db.handle = func(_ context.Context, query string, _ []interface{}) (*fakeRows, error) {
+		if strings.Contains(query, "duplicate") {
+			return nil, errors.New("unique violation")
+		}
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	tx, err := pg.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Savepoint("batch"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT duplicate"); err == nil {
+		t.Fatal("failing query succeeded")
+	}
+	if err := tx.RollbackTo("batch"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.ReleaseSavepoint("batch"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"BEGIN",
+		"INSERT first",
+		`SAVEPOINT "batch"`,
+		"INSERT duplicate",
+		`ROLLBACK TO SAVEPOINT "batch"`,
+		"INSERT second",
+		`RELEASE SAVEPOINT "batch"`,
+		"COMMIT",
+	}
+	if queries := db.executed(); !reflect.DeepEqual(queries, expected) {
+		t.Fatalf("executed %q, expected %q", queries, expected)
+	}
+}
+
+func TestLoadCancelled(t *testing.T) {
+	pg, db := newFakePostgres(t, DBConfig{})
+	db.handle = blockUntilCancelled
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	// драйвер возвращает собственную ошибку, вызывающий код получает причину отмены
+	if _, err := pg.Load(ctx, "SELECT pg_sleep(10)"); err != context.Canceled {
+		t.Fatalf("Load() error = %v, expected context.Canceled", err)
+	}
+	if _, err := pg.LoadArgs(ctx, "SELECT pg_sleep($1)", 10); err != context.Canceled {
+		t.Fatalf("LoadArgs() error = %v, expected context.Canceled", err)
+	}
+}
+
+func TestGeneratedQueriesQuoteIdentifiers(t *testing.T) {
+	pg := NewPostgres()
+	fields := []string{"select", "group"}
+
+	cases := []struct {
+		name, query, expected string
+	}{
+		{"insert", pg.generateInsertQuery("order", fields), `INSERT INTO "order" ("select","group") VALUES ($1,$2)`},
+		{"update", pg.generateUpdateQuery("order", fields, `"user" = $3`), `UPDATE "order" SET "select"=$1,"group"=$2 WHERE "user" = $3`},
+		{"on conflict", pg.generateOnConflictQuery(fields, []string{"user"}), ` ON CONFLICT ("user") DO UPDATE SET "select" = excluded."select", "group" = excluded."group"`},
+		{"on conflict bulk", pg.generateOnConflictBulkQuery(fields, []string{"user"}), ` ON CONFLICT ("user") DO UPDATE SET "select" = excluded."select", "group" = excluded."group"`},
+		{"delete by keys", pg.generateDeleteByKeysQuery("public.order", "user", 1), `DELETE FROM "public"."order" WHERE "user" IN ($1)`},
+	}
+	for _, c := range cases {
+		if strings.TrimSpace(c.query) != strings.TrimSpace(c.expected) {
+			t.Errorf("%s query %q, expected %q", c.name, c.query, c.expected)
+		}
+	}
+
+	// кавычки внутри имени экранируются удвоением
+	if quoted := quoteIdent(`bad"name`); quoted != `"bad""name"` {
+		t.Errorf("quoteIdent() = %s", quoted)
+	}
+}
+
+func TestAcquireClose(t *testing.T) {
+	pg, _ := newFakePostgres(t, DBConfig{})
+	ctx := context.Background()
+
+	first := pg.Acquire()
+	second := pg.Acquire()
+
+	// соединение закрывается только последним из пользователей
+	for i, user := range []*Postgres{first, second} {
+		if err := user.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pg.Exec(ctx, "SELECT 1"); err != nil {
+			t.Fatalf("Exec() after %d of 3 Close calls: %v", i+1, err)
+		}
+	}
+
+	if err := pg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pg.Exec(ctx, "SELECT 1"); err == nil {
+		t.Fatal("Exec() succeeded after the last Close")
+	}
+}
+
+func TestSetDebugLogsQuery(t *testing.T) {
+	pg, db := newFakePostgres(t, DBConfig{})
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	fields := []string{"id", "token"}
+	values := []interface{}{1, "secret-value"}
+
+	if _, err := pg.Save(ctx, "items", fields, values, []string{"id"}); err != nil {
+		t.Fatal(err)
+	}
+	if output.Len() != 0 {
+		t.Fatalf("query logged with debug disabled: %q", output.String())
+	}
+
+	pg.SetDebug(true)
+	if _, err := pg.Save(ctx, "items", fields, values, []string{"id"}); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := db.executed()
+	query := queries[len(queries)-1]
+	if !strings.Contains(query, "ON CONFLICT") {
+		t.Fatalf("Save() with keys executed %q", query)
+	}
+	logged := output.String()
+	if !strings.Contains(logged, "D> query: "+query) {
+		t.Fatalf("log %q doesn't contain query %q", logged, query)
+	}
+	if strings.Contains(logged, "secret-value") {
+		t.Fatalf("log contains query values: %q", logged)
+	}
+}
+
+func TestUpdateWherePlaceholders(t *testing.T) {
+	pg, db := newFakePostgres(t, DBConfig{})
+
+	set := map[string]interface{}{"status": "done", "attempts": 3}
+	where := map[string]interface{}{"user_id": 7, "id": 42}
+	if _, err := pg.UpdateWhere(context.Background(), "jobs", set, where); err != nil {
+		t.Fatal(err)
+	}
+
+	// плейсхолдеры условия продолжают нумерацию после плейсхолдеров SET
+	expected := `UPDATE "jobs" SET "attempts"=$1,"status"=$2 WHERE "id" = $3 AND "user_id" = $4`
+	if queries := db.executed(); len(queries) != 1 || queries[0] != expected {
+		t.Fatalf("executed %q, expected %q", queries, expected)
+	}
+	if args := db.args[0]; !reflect.DeepEqual(args, []interface{}{int64(3), "done", int64(42), int64(7)}) {
+		t.Fatalf("query args %#v", args)
+	}
+}
+
+type fakeSpanKey struct{}
+
+type fakeSpan struct {
+	query string
+	ended int
+	err   error
+}
+
+func (s *fakeSpan) End(_ time.Duration, err error) {
+	s.ended++
+	s.err = err
+}
+
+// создаёт span на каждый запрос и передаёт его через контекст
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (tr *fakeTracer) StartSpan(ctx context.Context, query string) (context.Context, QuerySpan) {
+	span := &fakeSpan{query: query}
+	tr.spans = append(tr.spans, span)
+	return context.WithValue(ctx, fakeSpanKey{}, span), span
+}
+
+func TestTracerSpanPerQuery(t *testing.T) {
+	pg, db := newFakePostgres(t, DBConfig{})
+	tracer := &fakeTracer{}
+	pg.SetTracer(tracer)
+
+	db.handle = func(ctx context.Context, query string, _ []interface{}) (*fakeRows, error) {
+		// запрос выполняется с контекстом, возвращённым трассировщиком
+		if span, _ := ctx.Value(fakeSpanKey{}).(*fakeSpan); span == nil || span.query != query {
+			t.Errorf("query %q executed without its span in context", query)
+		}
+		if strings.Contains(query, "broken") {
+			return nil, errors.New("syntax error")
+		}
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	if rows, err := pg.Exec(ctx, "SELECT 1"); err != nil {
+		t.Fatal(err)
+	} else {
+		rows.Close()
+	}
+	if rows, err := pg.LoadArgs(ctx, "SELECT $1", 1); err != nil {
+		t.Fatal(err)
+	} else {
+		rows.Close()
+	}
+	if _, err := pg.Save(ctx, "items", []string{"id"}, []interface{}{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pg.Exec(ctx, "SELECT broken"); err == nil {
+		t.Fatal("failing query succeeded")
+	}
+
+	queries := db.executed()
+	if len(tracer.spans) != len(queries) {
+		t.Fatalf("%d spans for %d queries", len(tracer.spans), len(queries))
+	}
+	for i, span := range tracer.spans {
+		if span.query != queries[i] || span.ended != 1 {
+			t.Errorf("span %d: query %q ended %d times, expected query %q ended once", i, span.query, span.ended, queries[i])
+		}
+	}
+	if last := tracer.spans[len(tracer.spans)-1]; last.err == nil {
+		t.Error("span of failing query ended without error")
+	}
+	if first := tracer.spans[0]; first.err != nil {
+		t.Errorf("span of successful query ended with %v", first.err)
+	}
+}
+
+func TestOnDataTyped(t *testing.T) {
+	pg := NewPostgres()
+
+	type event struct {
+		ID     int64  `json:"id"`
+		Status string `json:"status"`
+	}
+
+	var received []event
+	OnDataTyped(pg, func(channel string, value event) {
+		if channel != "events" {
+			t.Errorf("handler called for channel %q", channel)
+		}
+		received = append(received, value)
+	})
+
+	var errs []error
+	pg.OnError(func(err error) {
+		errs = append(errs, err)
+	})
+
+	pg.handler("events", `{"id": 5, "status": "done"}`)
+	if !reflect.DeepEqual(received, []event{{ID: 5, Status: "done"}}) || len(errs) != 0 {
+		t.Fatalf("received %v, errors %v", received, errs)
+	}
+
+	// некорректный payload передаётся в обработчик ошибок, типизированный обработчик не вызывается
+	pg.handler("events", `{"id": "five"}`)
+	if len(received) != 1 {
+		t.Fatalf("handler called for invalid payload: %v", received)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "can't decode notification payload, channel: events") {
+		t.Fatalf("errors %v", errs)
+	}
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+ProgressLogger - periodic logging of progress for long operations, logs processed count, rate and ETA
+once per interval from background RepeatableTask, Done must be called to stop it
+*/
+type ProgressLogger struct {
+	mu        sync.Mutex
+	name      string
+	total     int
+	processed int
+	// количество обработанных на момент предыдущей записи в лог
+	logged int
+	start  time.Time
+	now    func() time.Time
+	output func(string)
+	task   IAsyncTask
+}
+
+// total <= 0 - общее количество неизвестно, ETA не выводится
+func NewProgressLogger(name string, total int, interval time.Duration) *ProgressLogger {
+	ptr := newProgressLogger(name, total, time.Now)
+	ptr.task = NewRepeatableTaskOpts(ptr.logProgress, interval, false)
+	ptr.task.Execute()
+	return ptr
+}
+
+func newProgressLogger(name string, total int, now func() time.Time) *ProgressLogger {
+	return &ProgressLogger{
+		name:  name,
+		total: total,
+		start: now(),
+		now:   now,
+		output: func(msg string) {
+			log.Println(msg)
+		},
+	}
+}
+
+func (ptr *ProgressLogger) OnLog(handler func(string)) {
+	ptr.mu.Lock()
+	ptr.output = handler
+	ptr.mu.Unlock()
+}
+
+// processed - количество элементов, обработанных с предыдущего вызова
+func (ptr *ProgressLogger) Tick(processed int) {
+	ptr.mu.Lock()
+	ptr.processed += processed
+	ptr.mu.Unlock()
+}
+
+// без новых обработанных элементов с предыдущей записи лог не пишется
+func (ptr *ProgressLogger) logProgress() {
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	if ptr.processed == ptr.logged {
+		return
+	}
+	ptr.logged = ptr.processed
+
+	ptr.output("I> " + ptr.name + ": " + ptr.progress(ptr.now()))
+}
+
+func (ptr *ProgressLogger) Done() {
+	if ptr.task != nil {
+		ptr.task.BreakAndWait()
+	}
+
+	ptr.mu.Lock()
+	defer ptr.mu.Unlock()
+
+	elapsed := ptr.now().Sub(ptr.start)
+	ptr.output(fmt.Sprintf("I> %s: done, processed %d in %v", ptr.name, ptr.processed, elapsed.Round(time.Millisecond)))
+}
+
+func (ptr *ProgressLogger) progress(now time.Time) string {
+	elapsed := now.Sub(ptr.start)
+
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(ptr.processed) / elapsed.Seconds()
+	}
+
+	msg := fmt.Sprintf("processed %d", ptr.processed)
+	if ptr.total > 0 {
+		msg += fmt.Sprintf("/%d", ptr.total)
+	}
+	msg += fmt.Sprintf(", rate %.1f/s", rate)
+
+	if ptr.total > 0 && rate > 0 && ptr.processed < ptr.total {
+		eta := time.Duration(float64(ptr.total-ptr.processed) / rate * float64(time.Second))
+		msg += ", ETA " + eta.Round(time.Second).String()
+	}
+
+	return msg
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressLoggerFakeClock(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	progress := newProgressLogger("backfill", 100, func() time.Time { return now })
+
+	var logs []string
+	progress.OnLog(func(msg string) { logs = append(logs, msg) })
+
+	// 10 элементов за каждые 10 секунд, лог пишется на каждом интервале
+	for i := 0; i < 3; i++ {
+		now = now.Add(10 * time.Second)
+		progress.Tick(10)
+		progress.logProgress()
+	}
+	// интервал без обработанных элементов не логируется
+	now = now.Add(10 * time.Second)
+	progress.logProgress()
+
+	progress.Done()
+
+	expected := []string{
+		"I> backfill: processed 10/100, rate 1.0/s, ETA 1m30s",
+		"I> backfill: processed 20/100, rate 1.0/s, ETA 1m20s",
+		"I> backfill: processed 30/100, rate 1.0/s, ETA 1m10s",
+		"I> backfill: done, processed 30 in 40s",
+	}
+	if strings.Join(logs, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("logs:\n%s\nexpected:\n%s", strings.Join(logs, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestProgressLoggerInterval(t *testing.T) {
+	progress := NewProgressLogger("backfill", 0, 50*time.Millisecond)
+
+	var mu sync.Mutex
+	var logs int
+	progress.OnLog(func(string) {
+		mu.Lock()
+		logs++
+		mu.Unlock()
+	})
+
+	// частые вызовы Tick не увеличивают частоту записи в лог
+	deadline := time.Now().Add(230 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		progress.Tick(1)
+		time.Sleep(time.Millisecond)
+	}
+	progress.Done()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// 4 записи по интервалу и запись Done
+	if logs < 3 || logs > 6 {
+		t.Fatalf("%d log records in 230ms with 50ms interval", logs)
+	}
+}
@@ -0,0 +1,157 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// соответствие имени колонки (тег db) индексу поля структуры
+func structColumns(t reflect.Type) map[string]int {
+	columns := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("db"), ",")[0]
+		if len(tag) == 0 || tag == "-" {
+			continue
+		}
+		columns[tag] = i
+	}
+	return columns
+}
+
+// адреса полей структуры v для Scan в порядке columns, значения колонок без поля отбрасываются
+func structScanTargets(v reflect.Value, fields map[string]int, columns []string) []interface{} {
+	targets := make([]interface{}, len(columns))
+	for i, column := range columns {
+		if index, ok := fields[column]; ok {
+			targets[i] = v.Field(index).Addr().Interface()
+		} else {
+			targets[i] = new(interface{})
+		}
+	}
+	return targets
+}
+
+/*
+ScanRows - scanning all rows into dest (*[]Struct or *[]*Struct), columns are matched to struct fields by db tags,
+columns without field are skipped. Nullable columns should be scanned into sql.Null* or pointer fields.
+Rows are closed when done
+*/
+func ScanRows(rows *sql.Rows, dest interface{}) error {
+	defer rows.Close()
+
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Ptr || destValue.IsNil() || destValue.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("scan destination must be a pointer to slice, got %T", dest)
+	}
+
+	slice := destValue.Elem()
+	itemType := slice.Type().Elem()
+	isPtr := itemType.Kind() == reflect.Ptr
+	if isPtr {
+		itemType = itemType.Elem()
+	}
+	if itemType.Kind() != reflect.Struct {
+		return errors.New("scan destination element must be a struct, got " + itemType.String())
+	}
+	fields := structColumns(itemType)
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		item := reflect.New(itemType)
+		if err := rows.Scan(structScanTargets(item.Elem(), fields, columns)...); err != nil {
+			return err
+		}
+
+		if isPtr {
+			slice = reflect.Append(slice, item)
+		} else {
+			slice = reflect.Append(slice, item.Elem())
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	destValue.Elem().Set(slice)
+	return nil
+}
+
+/*
+LoadMap - selecting rows into map of structs V (fields are matched to columns by db tags)
+keyed by the value of keyField column
+*/
+func LoadMap[K comparable, V any](ctx context.Context, pg *Postgres, keyField string, query string, args ...interface{}) (map[K]V, error) {
+	valueType := reflect.TypeOf((*V)(nil)).Elem()
+	if valueType.Kind() != reflect.Struct {
+		return nil, errors.New("map value type must be a struct, got " + valueType.String())
+	}
+	fields := structColumns(valueType)
+
+	rows, err := pg.LoadArgs(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	keyIndex := -1
+	for i, column := range columns {
+		if column == keyField {
+			keyIndex = i
+			break
+		}
+	}
+	if keyIndex < 0 {
+		return nil, errors.New("key column " + keyField + " not found in query result")
+	}
+
+	result := make(map[K]V)
+	for rows.Next() {
+		var key K
+		var value V
+
+		item := reflect.ValueOf(&value).Elem()
+		targets := structScanTargets(item, fields, columns)
+		targets[keyIndex] = &key
+
+		if err := rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+
+		// колонка ключа сканируется в key, поле структуры с тем же тегом заполняется из него
+		if index, ok := fields[keyField]; ok {
+			keyValue := reflect.ValueOf(key)
+			field := item.Field(index)
+			if keyValue.Type().AssignableTo(field.Type()) {
+				field.Set(keyValue)
+			} else if keyValue.Type().ConvertibleTo(field.Type()) {
+				field.Set(keyValue.Convert(field.Type()))
+			}
+		}
+
+		result[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type scanUser struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestLoadMap(t *testing.T) {
+	pg, db := newFakePostgres(t, DBConfig{})
+	db.handle = func(context.Context, string, []interface{}) (*fakeRows, error) {
+		return newFakeRows([]string{"id", "name", "extra"},
+			[]driver.Value{int64(1), "alice", "x"},
+			[]driver.Value{int64(2), "bob", "y"},
+		), nil
+	}
+
+	users, err := LoadMap[int64, scanUser](context.Background(), pg, "id", "SELECT id, name, extra FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// поле с тегом колонки ключа заполняется вместе с ключом
+	expected := map[int64]scanUser{
+		1: {ID: 1, Name: "alice"},
+		2: {ID: 2, Name: "bob"},
+	}
+	if !reflect.DeepEqual(users, expected) {
+		t.Fatalf("LoadMap() = %v, expected %v", users, expected)
+	}
+
+	if _, err := LoadMap[int64, scanUser](context.Background(), pg, "user_id", "SELECT id, name FROM users"); err == nil || !strings.Contains(err.Error(), "key column user_id not found") {
+		t.Fatalf("LoadMap() with missing key column: %v", err)
+	}
+	if _, err := LoadMap[int64, int64](context.Background(), pg, "id", "SELECT id FROM users"); err == nil {
+		t.Fatal("LoadMap() with non-struct value type succeeded")
+	}
+}
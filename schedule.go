@@ -0,0 +1,141 @@
+package common
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+cronSchedule - расписание в формате cron из пяти полей: минута, час, день месяца, месяц, день недели.
+Поддерживаются значения *, числа, диапазоны (1-5), списки (1,15,30) и шаги (0-30/10, также допускается шаг для *).
+День недели задаётся числами 0-7, где 0 и 7 - воскресенье
+*/
+type cronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// при ограничении обоих полей день подходит, если совпадает хотя бы одно из них (как в cron)
+	domRestricted bool
+	dowRestricted bool
+}
+
+// ограничение поиска следующего времени запуска для расписаний вроде "0 0 31 2 *"
+const cronSearchYears = 5
+
+func parseCronSchedule(schedule string) (*cronSchedule, error) {
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return nil, errors.New("invalid cron schedule " + strconv.Quote(schedule) + ", expected 5 fields")
+	}
+
+	bounds := [5]struct{ min, max int }{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var masks [5]uint64
+	for i, field := range fields {
+		mask, err := parseCronField(field, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, errors.New(err.Error() + ", schedule: " + strconv.Quote(schedule))
+		}
+		masks[i] = mask
+	}
+
+	// 7 - альтернативное обозначение воскресенья
+	if masks[4]&(1<<7) != 0 {
+		masks[4] = masks[4]&^(1<<7) | 1
+	}
+
+	return &cronSchedule{
+		minute:        masks[0],
+		hour:          masks[1],
+		dom:           masks[2],
+		month:         masks[3],
+		dow:           masks[4],
+		domRestricted: !strings.HasPrefix(fields[2], "*"),
+		dowRestricted: !strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var mask uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		i := strings.Index(part, "/")
+		if i >= 0 {
+			var err error
+			rangePart = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.New("invalid step in cron field " + strconv.Quote(field))
+			}
+		}
+
+		from, to := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.New("invalid value in cron field " + strconv.Quote(field))
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.New("invalid range in cron field " + strconv.Quote(field))
+				}
+			} else if i >= 0 {
+				// "5/15" означает с 5 до конца диапазона с шагом 15
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return 0, errors.New("value out of range [" + strconv.Itoa(min) + ", " + strconv.Itoa(max) + "] in cron field " + strconv.Quote(field))
+		}
+
+		for v := from; v <= to; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+
+	return mask, nil
+}
+
+func (ptr *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := ptr.dom&(1<<uint(t.Day())) != 0
+	dowMatch := ptr.dow&(1<<uint(t.Weekday())) != 0
+
+	if ptr.domRestricted && ptr.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// ближайшее время запуска строго после from, нулевое время, если такого нет
+func (ptr *cronSchedule) next(from time.Time) time.Time {
+	t := from.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if ptr.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !ptr.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if ptr.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if ptr.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+)
+
+type groupCall struct {
+	wg     sync.WaitGroup
+	result interface{}
+	err    error
+}
+
+/*
+Group - coalescing concurrent calls with the same key into single execution
+*/
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*groupCall
+}
+
+/*
+Do - executing fn for the key, if fn for the same key is already running
+the caller waits for it and receives the same result
+*/
+func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*groupCall)
+	}
+
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.result, call.err
+	}
+
+	call := &groupCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	// после завершения последующие вызовы с этим ключом снова выполняют fn
+	defer func() {
+		// паника fn возвращается ожидающим как ошибка, а у вызвавшего fn продолжается
+		p := recover()
+		if p != nil {
+			call.result, call.err = nil, fmt.Errorf("call for key %s panicked: %v", key, p)
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+
+		if p != nil {
+			panic(p)
+		}
+	}()
+
+	call.result, call.err = fn()
+
+	return call.result, call.err
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupDoCoalescesCalls(t *testing.T) {
+	var g Group
+	var executions int64
+	release := make(chan struct{})
+
+	wg := sync.WaitGroup{}
+	results := make([]interface{}, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := g.Do("key", func() (interface{}, error) {
+				atomic.AddInt64(&executions, 1)
+				<-release
+				return "value", nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = result
+		}(i)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt64(&executions); n != 1 {
+		t.Fatalf("fn executed %d times, expected 1", n)
+	}
+	for i, result := range results {
+		if result != "value" {
+			t.Fatalf("caller %d got %v", i, result)
+		}
+	}
+
+	// после завершения fn выполняется снова
+	if _, err := g.Do("key", func() (interface{}, error) { return nil, errors.New("second") }); err == nil || err.Error() != "second" {
+		t.Fatalf("second call error = %v", err)
+	}
+}
+
+func TestGroupDoPanic(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	release := make(chan struct{})
+	leaderPanic := make(chan interface{}, 1)
+
+	go func() {
+		defer func() { leaderPanic <- recover() }()
+		g.Do("key", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+
+	<-started
+	waiterErr := make(chan error, 1)
+	go func() {
+		_, err := g.Do("key", func() (interface{}, error) { return "unexpected", nil })
+		waiterErr <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if p := <-leaderPanic; p != "boom" {
+		t.Fatalf("leader recovered %v, expected re-panic", p)
+	}
+	if err := <-waiterErr; err == nil || !strings.Contains(err.Error(), "panicked: boom") {
+		t.Fatalf("waiter error = %v", err)
+	}
+
+	// ключ освобождается и после паники
+	if result, err := g.Do("key", func() (interface{}, error) { return "ok", nil }); err != nil || result != "ok" {
+		t.Fatalf("call after panic = %v, %v", result, err)
+	}
+}
@@ -1,18 +1,64 @@
 package common
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"time"
 )
 
+// нулевое время (time.Time{}) означает "время не задано" и преобразуется в 0
 func GetUnixMilliseconds(time time.Time) int64 {
+	if time.IsZero() {
+		return 0
+	}
 	return time.UnixNano() / 1000000
 }
 
+// 0 означает "время не задано" и преобразуется в нулевое время (time.Time{}), а не в начало эпохи Unix
 func FromUnixMilliseconds(milliseconds int64) time.Time {
+	if IsZeroMillis(milliseconds) {
+		return time.Time{}
+	}
 	return FromUnixNanoseconds(MillisecondsToNanoseconds(milliseconds))
 }
 
+func IsZeroMillis(milliseconds int64) bool {
+	return milliseconds == 0
+}
+
+// нулевое время преобразуется в 0, как и в GetUnixMilliseconds
+func GetUnixSeconds(time time.Time) int64 {
+	if time.IsZero() {
+		return 0
+	}
+	return time.Unix()
+}
+
+// 0 преобразуется в нулевое время, как и в FromUnixMilliseconds
+func FromUnixSeconds(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+func GetUnixMicroseconds(time time.Time) int64 {
+	if time.IsZero() {
+		return 0
+	}
+	return time.UnixMicro()
+}
+
+func FromUnixMicroseconds(microseconds int64) time.Time {
+	if microseconds == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(microseconds)
+}
+
 func FromUnixNanoseconds(nanoseconds int64) time.Time {
 	return time.Unix(0, nanoseconds)
 }
@@ -21,6 +67,178 @@ func MillisecondsToNanoseconds(milliseconds int64) int64 {
 	return milliseconds * int64(time.Millisecond/time.Nanosecond)
 }
 
+// в отличие от MillisecondsToNanoseconds возвращает ошибку, если результат не помещается в int64
+func MillisecondsToNanosecondsChecked(milliseconds int64) (int64, error) {
+	factor := int64(time.Millisecond / time.Nanosecond)
+	if milliseconds > math.MaxInt64/factor || milliseconds < math.MinInt64/factor {
+		return 0, fmt.Errorf("milliseconds value %d overflows nanoseconds", milliseconds)
+	}
+	return milliseconds * factor, nil
+}
+
+// в отличие от FromUnixMilliseconds возвращает ошибку при переполнении вместо некорректного времени
+func FromUnixMillisecondsChecked(milliseconds int64) (time.Time, error) {
+	if IsZeroMillis(milliseconds) {
+		return time.Time{}, nil
+	}
+	nanoseconds, err := MillisecondsToNanosecondsChecked(milliseconds)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return FromUnixNanoseconds(nanoseconds), nil
+}
+
 func MillisecondsFloatToNanoseconds(milliseconds float64) int64 {
 	return int64(math.Round(milliseconds * float64(time.Millisecond/time.Nanosecond)))
 }
+
+/*
+Duration - time.Duration decoded from config JSON either as a string in time.ParseDuration format ("30s", "5m")
+or as a number of seconds
+*/
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	// как и для остальных типов в encoding/json, null оставляет значение без изменений
+	if string(bytes.TrimSpace(data)) == "null" {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(math.Round(v * float64(time.Second)))
+	case string:
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, %v", v, err)
+		}
+		*d = Duration(duration)
+	default:
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+
+	return nil
+}
+
+/*
+Deadline - point in time checked by monotonic clock, so wall clock adjustments don't affect it
+*/
+type Deadline struct {
+	start    time.Time
+	duration time.Duration
+}
+
+func NewDeadline(d time.Duration) *Deadline {
+	return &Deadline{
+		start:    time.Now(),
+		duration: d,
+	}
+}
+
+func (d *Deadline) Exceeded() bool {
+	return time.Since(d.start) >= d.duration
+}
+
+// после истечения дедлайна возвращает 0
+func (d *Deadline) Remaining() time.Duration {
+	remaining := d.duration - time.Since(d.start)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// начало часа в часовом поясе t, корректно для поясов со смещением не кратным часу и при переходе на зимнее время,
+// вычисляется по абсолютному времени с учётом смещения пояса, а не через time.Date, выбирающий смещение неоднозначно
+func TruncateToHour(t time.Time) time.Time {
+	_, offset := t.Zone()
+	seconds := (t.Unix() + int64(offset)) % 3600
+	if seconds < 0 {
+		seconds += 3600
+	}
+	return t.Add(-time.Duration(seconds)*time.Second - time.Duration(t.Nanosecond()))
+}
+
+// начало суток в часовом поясе loc, длительность суток при переходе на летнее время может отличаться от 24 часов
+func TruncateToDay(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// начало окна длительностью windowMillis, в которое попадает ms, отрицательные значения округляются вниз,
+// при windowMillis <= 0 ms возвращается без изменений
+func BucketMillis(ms int64, windowMillis int64) int64 {
+	if windowMillis <= 0 {
+		return ms
+	}
+	bucket := ms - ms%windowMillis
+	if ms%windowMillis < 0 {
+		bucket -= windowMillis
+	}
+	return bucket
+}
+
+/*
+FloorToInterval - start of interval-long bucket containing t, buckets are counted from Unix epoch
+(not calendar-aware), so t already on the boundary is returned as is
+*/
+func FloorToInterval(t time.Time, interval time.Duration) time.Time {
+	if interval <= 0 || t.IsZero() {
+		return t
+	}
+	nanoseconds := t.UnixNano()
+	remainder := nanoseconds % int64(interval)
+	if remainder < 0 {
+		remainder += int64(interval)
+	}
+	return FromUnixNanoseconds(nanoseconds - remainder).In(t.Location())
+}
+
+// ближайшая к t сверху граница интервала, t на границе возвращается без изменений
+func CeilToInterval(t time.Time, interval time.Duration) time.Time {
+	floor := FloorToInterval(t, interval)
+	if floor.Equal(t) {
+		return t
+	}
+	return floor.Add(interval)
+}
+
+// RFC3339 с дробной частью секунд или без неё в миллисекунды Unix
+func ParseRFC3339Millis(s string) (int64, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse RFC3339 time %q, %v", s, err)
+	}
+	return GetUnixMilliseconds(t), nil
+}
+
+// вызов fn для start, start+step, ... пока время не превышает end, обход прекращается при первой ошибке fn
+func IterateTimeRange(start, end time.Time, step time.Duration, fn func(time.Time) error) error {
+	if step <= 0 {
+		return errors.New("time range step must be greater than zero")
+	}
+	if start.After(end) {
+		return errors.New("time range start is after end")
+	}
+
+	for t := start; !t.After(end); t = t.Add(step) {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
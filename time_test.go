@@ -0,0 +1,271 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTruncateToHourDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database is not available: ", err)
+	}
+
+	// 1 ноября 2026 01:00-02:00 проходит дважды: сначала по EDT (UTC-4), затем по EST (UTC-5)
+	cases := []struct {
+		utc, expected time.Time
+	}{
+		{time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC), time.Date(2026, 11, 1, 5, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 11, 1, 6, 30, 0, 0, time.UTC), time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC)},
+		// переход на летнее время: 02:00 EST сразу становится 03:00 EDT
+		{time.Date(2026, 3, 8, 7, 59, 59, 0, time.UTC), time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 8, 7, 30, 0, 0, time.UTC), time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		local := c.utc.In(loc)
+		if result := TruncateToHour(local); !result.Equal(c.expected) {
+			t.Errorf("TruncateToHour(%v) = %v, expected %v", local, result, c.expected.In(loc))
+		}
+	}
+}
+
+func TestTruncateToHourHalfHourOffset(t *testing.T) {
+	loc := time.FixedZone("IST", 5*3600+1800)
+	value := time.Date(2026, 10, 16, 10, 45, 12, 500, loc)
+
+	expected := time.Date(2026, 10, 16, 10, 0, 0, 0, loc)
+	if result := TruncateToHour(value); !result.Equal(expected) {
+		t.Errorf("TruncateToHour(%v) = %v, expected %v", value, result, expected)
+	}
+}
+
+func TestBucketMillis(t *testing.T) {
+	cases := []struct {
+		ms, window, expected int64
+	}{
+		{1500, 1000, 1000},
+		{2000, 1000, 2000},
+		{-1, 1000, -1000},
+		{1500, 0, 1500},
+		{1500, -10, 1500},
+	}
+
+	for _, c := range cases {
+		if result := BucketMillis(c.ms, c.window); result != c.expected {
+			t.Errorf("BucketMillis(%d, %d) = %d, expected %d", c.ms, c.window, result, c.expected)
+		}
+	}
+}
+
+func TestDurationUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected time.Duration
+		valid    bool
+	}{
+		{`30`, 30 * time.Second, true},
+		{`1.5`, 1500 * time.Millisecond, true},
+		{`"30s"`, 30 * time.Second, true},
+		{`"5m"`, 5 * time.Minute, true},
+		{`"1h30m"`, 90 * time.Minute, true},
+		{`"soon"`, 0, false},
+		{`true`, 0, false},
+	}
+
+	for _, c := range cases {
+		var d Duration
+		err := json.Unmarshal([]byte(c.data), &d)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: error %v, expected valid %v", c.data, err, c.valid)
+			continue
+		}
+		if c.valid && d.Duration() != c.expected {
+			t.Errorf("%s: got %v, expected %v", c.data, d.Duration(), c.expected)
+		}
+	}
+}
+
+func TestDurationUnmarshalJSONNull(t *testing.T) {
+	config := ModuleConfig{StartTimeout: Duration(time.Second)}
+	if err := json.Unmarshal([]byte(`{"start_timeout": null, "stop_timeout": "2s"}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.StartTimeout.Duration() != time.Second || config.StopTimeout.Duration() != 2*time.Second {
+		t.Fatalf("start %v, stop %v", config.StartTimeout.Duration(), config.StopTimeout.Duration())
+	}
+}
+
+func TestZeroTimeMillisRoundTrip(t *testing.T) {
+	if ms := GetUnixMilliseconds(time.Time{}); ms != 0 {
+		t.Fatalf("GetUnixMilliseconds(zero) = %d", ms)
+	}
+	if !FromUnixMilliseconds(0).IsZero() {
+		t.Fatalf("FromUnixMilliseconds(0) = %v", FromUnixMilliseconds(0))
+	}
+	if !IsZeroMillis(GetUnixMilliseconds(time.Time{})) || IsZeroMillis(1) {
+		t.Fatal("IsZeroMillis mismatch")
+	}
+	if GetUnixSeconds(time.Time{}) != 0 || !FromUnixSeconds(0).IsZero() {
+		t.Fatal("zero time seconds round trip failed")
+	}
+	if GetUnixMicroseconds(time.Time{}) != 0 || !FromUnixMicroseconds(0).IsZero() {
+		t.Fatal("zero time microseconds round trip failed")
+	}
+
+	now := time.Date(2024, 3, 1, 12, 30, 15, 123000000, time.UTC)
+	if restored := FromUnixMilliseconds(GetUnixMilliseconds(now)); !restored.Equal(now) {
+		t.Fatalf("round trip of %v = %v", now, restored)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	deadline := NewDeadline(50 * time.Millisecond)
+	if deadline.Exceeded() {
+		t.Fatal("deadline exceeded immediately")
+	}
+
+	first := deadline.Remaining()
+	time.Sleep(10 * time.Millisecond)
+	if second := deadline.Remaining(); second >= first || second <= 0 {
+		t.Fatalf("Remaining() didn't decrease: %v, then %v", first, second)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if !deadline.Exceeded() {
+		t.Fatal("deadline not exceeded after its duration")
+	}
+	if remaining := deadline.Remaining(); remaining != 0 {
+		t.Fatalf("Remaining() after deadline = %v", remaining)
+	}
+}
+
+func TestParseRFC3339Millis(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected int64
+	}{
+		{"2024-01-01T00:00:00Z", 1704067200000},
+		{"2024-01-01T00:00:00.123Z", 1704067200123},
+		{"2024-01-01T00:00:00.123456789Z", 1704067200123},
+		{"2024-01-01T03:00:00+03:00", 1704067200000},
+		{"2023-12-31T19:00:00.5-05:00", 1704067200500},
+	}
+	for _, c := range cases {
+		if ms, err := ParseRFC3339Millis(c.value); err != nil || ms != c.expected {
+			t.Errorf("ParseRFC3339Millis(%q) = %d, %v, expected %d", c.value, ms, err, c.expected)
+		}
+	}
+
+	for _, value := range []string{"", "2024-01-01", "2024-01-01 00:00:00Z", "yesterday"} {
+		if _, err := ParseRFC3339Millis(value); err == nil {
+			t.Errorf("ParseRFC3339Millis(%q) succeeded", value)
+		}
+	}
+}
+
+func TestMillisecondsToNanosecondsChecked(t *testing.T) {
+	limit := int64(math.MaxInt64 / 1000000)
+
+	if ns, err := MillisecondsToNanosecondsChecked(limit); err != nil || ns != limit*1000000 {
+		t.Fatalf("MillisecondsToNanosecondsChecked(%d) = %d, %v", limit, ns, err)
+	}
+	for _, ms := range []int64{limit + 1, -limit - 1, math.MaxInt64, math.MinInt64} {
+		if _, err := MillisecondsToNanosecondsChecked(ms); err == nil {
+			t.Errorf("overflow of %d not detected", ms)
+		}
+		if _, err := FromUnixMillisecondsChecked(ms); err == nil {
+			t.Errorf("FromUnixMillisecondsChecked(%d) overflow not detected", ms)
+		}
+	}
+
+	if tm, err := FromUnixMillisecondsChecked(1704067200123); err != nil || GetUnixMilliseconds(tm) != 1704067200123 {
+		t.Fatalf("FromUnixMillisecondsChecked() = %v, %v", tm, err)
+	}
+}
+
+func TestIterateTimeRange(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	collect := func(end time.Time, step time.Duration) ([]time.Time, error) {
+		var visited []time.Time
+		err := IterateTimeRange(start, end, step, func(t time.Time) error {
+			visited = append(visited, t)
+			return nil
+		})
+		return visited, err
+	}
+
+	// конец диапазона на границе шага включается
+	visited, err := collect(start.Add(3*time.Hour), time.Hour)
+	if err != nil || len(visited) != 4 || !visited[3].Equal(start.Add(3*time.Hour)) {
+		t.Fatalf("exact range: %v, %v", visited, err)
+	}
+
+	// шаг не кратен диапазону, последнее значение не превышает конец
+	visited, err = collect(start.Add(100*time.Minute), 45*time.Minute)
+	if err != nil || len(visited) != 3 || !visited[2].Equal(start.Add(90*time.Minute)) {
+		t.Fatalf("non-exact range: %v, %v", visited, err)
+	}
+
+	if visited, err = collect(start, time.Hour); err != nil || len(visited) != 1 {
+		t.Fatalf("empty range: %v, %v", visited, err)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = IterateTimeRange(start, start.Add(10*time.Hour), time.Hour, func(time.Time) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || calls != 2 {
+		t.Fatalf("iteration not stopped on error: %v after %d calls", err, calls)
+	}
+
+	if _, err := collect(start.Add(time.Hour), 0); err == nil {
+		t.Error("zero step accepted")
+	}
+	if _, err := collect(start.Add(-time.Hour), time.Minute); err == nil {
+		t.Error("start after end accepted")
+	}
+}
+
+func TestFloorCeilToInterval(t *testing.T) {
+	at := func(hour, minute, second int) time.Time {
+		return time.Date(2024, 3, 10, hour, minute, second, 0, time.UTC)
+	}
+
+	cases := []struct {
+		t           time.Time
+		interval    time.Duration
+		floor, ceil time.Time
+	}{
+		{at(10, 7, 30), time.Minute, at(10, 7, 0), at(10, 8, 0)},
+		{at(10, 7, 30), 5 * time.Minute, at(10, 5, 0), at(10, 10, 0)},
+		{at(10, 7, 30), time.Hour, at(10, 0, 0), at(11, 0, 0)},
+		// время на границе интервала не меняется
+		{at(10, 15, 0), 5 * time.Minute, at(10, 15, 0), at(10, 15, 0)},
+		{at(10, 0, 0), time.Hour, at(10, 0, 0), at(10, 0, 0)},
+		{at(10, 7, 30), 0, at(10, 7, 30), at(10, 7, 30)},
+	}
+
+	for _, c := range cases {
+		if floor := FloorToInterval(c.t, c.interval); !floor.Equal(c.floor) {
+			t.Errorf("FloorToInterval(%v, %v) = %v, expected %v", c.t, c.interval, floor, c.floor)
+		}
+		if ceil := CeilToInterval(c.t, c.interval); !ceil.Equal(c.ceil) {
+			t.Errorf("CeilToInterval(%v, %v) = %v, expected %v", c.t, c.interval, ceil, c.ceil)
+		}
+	}
+
+	// результат остаётся в часовом поясе t
+	loc := time.FixedZone("UTC+3", 3*3600)
+	if floor := FloorToInterval(at(10, 7, 30).In(loc), time.Hour); floor.Location() != loc || floor.Hour() != 13 {
+		t.Errorf("FloorToInterval() in %v = %v", loc, floor)
+	}
+}